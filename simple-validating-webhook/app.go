@@ -2,19 +2,30 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 
+	"k8s.io/client-go/informers"
+	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/util/homedir"
 
 	"k8s.io/client-go/kubernetes"
+
+	"simple-validating-webhook/webhook"
 )
 
 // Application holds an instance of an application
@@ -23,15 +34,122 @@ type application struct {
 	infoLog  *log.Logger
 	cfg      *envConfig
 	client   kubernetes.Interface
+	messages messageTemplates
+	selector labels.Selector // parsed NAMESPACE_SELECTOR, nil when not set
+	teams    *teamRegistry   // nil when no team registry is configured
+	celRules []celRule       // compiled CEL rules from CEL_RULES_PATH
+	rego     *regoPolicy     // nil when no Rego policy directory is configured
+	wasm     *wasmPlugins    // nil when no WASM plugin directory is configured
+
+	endpoints []*endpoint                   // endpoints from ENDPOINTS_PATH, /validate is served when empty
+	decisions *decisionStore                // nil when no decision history is configured
+	scanner   *scanner                      // nil when the background scan is disabled
+	reports   *policyReporter               // nil when the PolicyReports are disabled
+	elector   *leaderelection.LeaderElector // nil when the leader election is disabled
+	auth      *clientAuthenticator          // nil when the callers are not authenticated
+	limiter   *webhook.Limiter              // nil when the admission requests are not limited
+
+	namespaceLister  corev1listers.NamespaceLister // nil when the namespace cache is disabled
+	namespacesSynced cache.InformerSynced
+	certificate      *x509.Certificate // serving certificate, checked by /readyz
+	inflight         *inflightTracker  // admission requests in flight, checked by /livez
+	draining         *atomic.Bool      // set on shutdown to fail /readyz, nil in tests
 }
 
 // type envConfig holds various environment variables
 type envConfig struct {
-	CertPath   string `env:"CERT_PATH" envDefault:"/source/cert.pem"`
-	KeyPath    string `env:"KEY_PATH" envDefault:"/source/key.pem"`
-	Port       int    `env:"PORT" envDefault:"3000"`
+	CertPath    string `env:"CERT_PATH" envDefault:"/source/cert.pem"`
+	KeyPath     string `env:"KEY_PATH" envDefault:"/source/key.pem"`
+	Port        int    `env:"PORT" envDefault:"3000"`
+	AdminPort   int    `env:"ADMIN_PORT" envDefault:"8081"`
+	EnablePprof bool   `env:"ENABLE_PPROF" envDefault:"false"`
+
+	AccessLog           bool  `env:"ACCESS_LOG" envDefault:"true"`
+	MaxRequestBodyBytes int64 `env:"MAX_REQUEST_BODY_BYTES" envDefault:"10485760"`
+
+	MaxInFlightAdmissions int           `env:"MAX_INFLIGHT_ADMISSIONS"`
+	MaxQueuedAdmissions   int           `env:"MAX_QUEUED_ADMISSIONS" envDefault:"100"`
+	AdmissionQueueTimeout time.Duration `env:"ADMISSION_QUEUE_TIMEOUT" envDefault:"1s"`
+
+	TLSProfile      string   `env:"TLS_PROFILE" envDefault:"intermediate"`
+	TLSMinVersion   string   `env:"TLS_MIN_VERSION"`
+	TLSCipherSuites []string `env:"TLS_CIPHER_SUITES" envSeparator:","`
+	TLSCurves       []string `env:"TLS_CURVES" envSeparator:","`
+
 	Annotation string `env:"ANNOTATION" envDefault:"example.com/validate"`
 	Label      string `env:"LABEL" envDefault:"owner"`
+
+	MessageTemplatesPath string `env:"MESSAGE_TEMPLATES_PATH"`
+	DocsURL              string `env:"DOCS_URL"`
+	ContactAnnotation    string `env:"CONTACT_ANNOTATION" envDefault:"example.com/contact"`
+	DefaultContact       string `env:"DEFAULT_CONTACT"`
+
+	NamespaceSelector string `env:"NAMESPACE_SELECTOR"`
+	NamespaceMode     string `env:"NAMESPACE_MODE" envDefault:"opt-in"`
+
+	RequiredLabelsAnnotation string `env:"REQUIRED_LABELS_ANNOTATION" envDefault:"example.com/required-labels"`
+
+	NamespaceRequiredLabels      []string `env:"NAMESPACE_REQUIRED_LABELS" envSeparator:","`
+	NamespaceRequiredAnnotations []string `env:"NAMESPACE_REQUIRED_ANNOTATIONS" envSeparator:","`
+	AdminUsers                   []string `env:"ADMIN_USERS" envSeparator:","`
+	AdminGroups                  []string `env:"ADMIN_GROUPS" envDefault:"system:masters" envSeparator:","`
+
+	TeamRegistryPath         string        `env:"TEAM_REGISTRY_PATH"`
+	TeamRegistryConfigMap    string        `env:"TEAM_REGISTRY_CONFIGMAP"`
+	TeamRegistryKey          string        `env:"TEAM_REGISTRY_KEY" envDefault:"teams.yaml"`
+	TeamRegistryPollInterval time.Duration `env:"TEAM_REGISTRY_POLL_INTERVAL" envDefault:"30s"`
+
+	CELRulesPath string `env:"CEL_RULES_PATH"`
+
+	RegoPolicyDir    string        `env:"REGO_POLICY_DIR"`
+	RegoDecisionPath string        `env:"REGO_DECISION_PATH" envDefault:"kubernetes/admission/decision"`
+	RegoPollInterval time.Duration `env:"REGO_POLL_INTERVAL" envDefault:"30s"`
+
+	WASMPluginDir        string        `env:"WASM_PLUGIN_DIR"`
+	WASMMemoryLimitPages uint32        `env:"WASM_MEMORY_LIMIT_PAGES" envDefault:"256"`
+	WASMTimeout          time.Duration `env:"WASM_TIMEOUT" envDefault:"1s"`
+	WASMMaxConcurrency   int           `env:"WASM_MAX_CONCURRENCY" envDefault:"4"`
+	WASMFailurePolicy    string        `env:"WASM_FAILURE_POLICY" envDefault:"Fail"`
+
+	EndpointsPath string `env:"ENDPOINTS_PATH"`
+
+	FailurePolicy string `env:"FAILURE_POLICY" envDefault:"Fail"`
+
+	DecisionsDBPath        string        `env:"DECISIONS_DB_PATH"`
+	DecisionsRetention     time.Duration `env:"DECISIONS_RETENTION" envDefault:"720h"`
+	DecisionsPruneInterval time.Duration `env:"DECISIONS_PRUNE_INTERVAL" envDefault:"1h"`
+
+	ScanInterval time.Duration `env:"SCAN_INTERVAL"`
+
+	PolicyReports        bool          `env:"POLICY_REPORTS" envDefault:"false"`
+	PolicyReportInterval time.Duration `env:"POLICY_REPORT_INTERVAL" envDefault:"30s"`
+
+	LeaderElection              bool          `env:"LEADER_ELECTION" envDefault:"false"`
+	LeaderElectionNamespace     string        `env:"LEADER_ELECTION_NAMESPACE" envDefault:"webhook-demo"`
+	LeaderElectionLease         string        `env:"LEADER_ELECTION_LEASE" envDefault:"simple-validating-webhook"`
+	LeaderElectionLeaseDuration time.Duration `env:"LEADER_ELECTION_LEASE_DURATION" envDefault:"15s"`
+	LeaderElectionRenewDeadline time.Duration `env:"LEADER_ELECTION_RENEW_DEADLINE" envDefault:"10s"`
+	LeaderElectionRetryPeriod   time.Duration `env:"LEADER_ELECTION_RETRY_PERIOD" envDefault:"2s"`
+	PodName                     string        `env:"POD_NAME"`
+
+	NamespaceCache         bool          `env:"NAMESPACE_CACHE" envDefault:"true"`
+	LivenessStuckThreshold time.Duration `env:"LIVENESS_STUCK_THRESHOLD" envDefault:"60s"`
+
+	ShutdownDelay   time.Duration `env:"SHUTDOWN_DELAY" envDefault:"5s"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"20s"`
+
+	TracingExporter     string  `env:"TRACING_EXPORTER" envDefault:"none"`
+	TracingOTLPEndpoint string  `env:"TRACING_OTLP_ENDPOINT"`
+	TracingFile         string  `env:"TRACING_FILE"`
+	TracingSampleRatio  float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+	TracingServiceName  string  `env:"TRACING_SERVICE_NAME" envDefault:"simple-validating-webhook"`
+
+	ClientCAPath             string        `env:"CLIENT_CA_PATH"`
+	ClientAuthTokenReview    bool          `env:"CLIENT_AUTH_TOKEN_REVIEW" envDefault:"false"`
+	ClientAuthTokenAudiences []string      `env:"CLIENT_AUTH_TOKEN_AUDIENCES" envSeparator:","`
+	ClientAuthCacheTTL       time.Duration `env:"CLIENT_AUTH_CACHE_TTL" envDefault:"1m"`
+	TrustedClientUsers       []string      `env:"TRUSTED_CLIENT_USERS" envSeparator:","`
+	TrustedClientGroups      []string      `env:"TRUSTED_CLIENT_GROUPS" envSeparator:","`
 }
 
 // GetKubeConfig - return a valid kube config or an error
@@ -76,27 +194,103 @@ func NewKubeClient(config *rest.Config) (kubernetes.Interface, error) {
 	return kubernetes.NewForConfig(config)
 }
 
-// CheckNamespaceAnnotationTrue - returns true if the value of an annotationKey is present and set to true on a namespace
-func (app *application) CheckNamespaceAnnotationTrue(annotation, namespace string) (bool, error) {
+// getNamespace - fetches the namespace object from the Kubernetes API server
+func (app *application) getNamespace(ctx context.Context, namespace string) (*corev1.Namespace, error) {
 
 	if app == nil || app.client == nil {
-		return false, fmt.Errorf("application or client is nil")
+		return nil, fmt.Errorf("application or client is nil")
 	}
 
-	ns, err := app.client.CoreV1().Namespaces().Get(context.Background(), namespace, metav1.GetOptions{})
+	// a namespace created after the last update of the cache is fetched from the API server
+	if app.namespacesSynced != nil && app.namespacesSynced() {
+		if ns, err := app.namespaceLister.Get(namespace); err == nil {
+			return ns, nil
+		}
+	}
+
+	ns, err := app.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
 
 	if err != nil {
 		nsErr := fmt.Errorf("error checking annotations on the namespace %v - %v", namespace, err)
-		app.errorLog.Println(nsErr)
-		return false, nsErr
+		webhook.Logf(ctx, app.errorLog, "%v", nsErr)
+		return nil, nsErr
+	}
+
+	return ns, nil
+}
+
+// startNamespaceCache - starts an informer that keeps the namespaces in memory, getNamespace reads
+// them from the cache once it has synced instead of calling the API server for every request
+func (app *application) startNamespaceCache(ctx context.Context) {
+
+	factory := informers.NewSharedInformerFactory(app.client, 0)
+	namespaces := factory.Core().V1().Namespaces()
+
+	app.namespaceLister = namespaces.Lister()
+	app.namespacesSynced = namespaces.Informer().HasSynced
+
+	factory.Start(ctx.Done())
+}
+
+// CheckNamespaceAnnotationTrue - returns true if the value of an annotationKey is present and set to true on a namespace
+func (app *application) CheckNamespaceAnnotationTrue(ctx context.Context, annotation, namespace string) (bool, error) {
+
+	ns, err := app.getNamespace(ctx, namespace)
+
+	if err != nil {
+		return false, err
 	}
 
+	return app.namespaceAnnotationTrue(ns, annotation), nil
+}
+
+// namespaceAnnotationTrue - returns true if the annotation is present and set to true on the namespace object
+func (app *application) namespaceAnnotationTrue(ns *corev1.Namespace, annotation string) bool {
+
 	for key, val := range ns.GetAnnotations() {
 		if key == annotation && strings.ToLower(val) == "true" {
-			app.infoLog.Printf("Found annotationKey %v set to value %v in the namespace %v", annotation, val, namespace)
-			return true, nil
+			app.infoLog.Printf("Found annotationKey %v set to value %v in the namespace %v", annotation, val, ns.Name)
+			return true
 		}
 	}
 
-	return false, nil
+	return false
+}
+
+// remediationContact - returns the contact from the namespace annotation, falling back to the contacts of the
+// registered team named by the owner label and to DEFAULT_CONTACT
+func (app *application) remediationContact(ns *corev1.Namespace, owner string) string {
+
+	if contact := ns.GetAnnotations()[app.cfg.ContactAnnotation]; app.cfg.ContactAnnotation != "" && contact != "" {
+		return contact
+	}
+
+	if app.teams != nil && owner != "" {
+		if t, ok := app.teams.lookup(owner); ok && len(t.Contacts) > 0 {
+			return strings.Join(t.Contacts, ", ")
+		}
+	}
+
+	return app.cfg.DefaultContact
+}
+
+// denialMessage - renders the denial message of a rule, falling back to the default templates
+// when no templates were loaded
+func (app *application) denialMessage(rule string, data messageData) string {
+
+	messages := app.messages
+	if messages == nil {
+		messages = defaultMessages
+	}
+
+	data.Rule = rule
+	data.DocsURL = app.cfg.DocsURL
+
+	msg, err := messages.render(rule, data)
+	if err != nil {
+		app.errorLog.Println(err)
+		return fmt.Sprintf("Denied because rule %v failed for label %v", rule, data.Label)
+	}
+
+	return msg
 }
@@ -82,7 +82,7 @@ func Test_application_CheckNamespaceAnnotationTrue(t *testing.T) {
 				}
 			}
 			
-			got, err := app.CheckNamespaceAnnotationTrue(tt.annotationToCheck, tt.namespaceName)
+			got, err := app.CheckNamespaceAnnotationTrue(context.Background(), tt.annotationToCheck, tt.namespaceName)
 			
 			t.Log("function call returned", got, err)
 			
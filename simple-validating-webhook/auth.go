@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// clientIdentity is the authenticated caller of the admission endpoints
+type clientIdentity struct {
+	Method string // client-cert or token
+	User   string
+	Groups []string
+}
+
+const (
+	// maxCachedReviews - TokenReview results kept, the ones that expire first are evicted
+	maxCachedReviews = 1024
+	// unauthenticatedReviewTTL - upper bound of the time a rejected token is cached, a token that is not
+	// valid yet is accepted soon after
+	unauthenticatedReviewTTL = 5 * time.Second
+)
+
+// cachedReview is a TokenReview result kept for CLIENT_AUTH_CACHE_TTL, or unauthenticatedReviewTTL when the
+// token was not authenticated
+type cachedReview struct {
+	identity *clientIdentity // nil when the token was not authenticated
+	expires  time.Time
+}
+
+// clientAuthenticator authenticates the callers of the admission endpoints with a client certificate verified
+// by the TLS listener against CLIENT_CA_PATH, or with a bearer token validated by a TokenReview
+type clientAuthenticator struct {
+	client        kubernetes.Interface // nil when the bearer tokens are not accepted
+	audiences     []string
+	cacheTTL      time.Duration
+	trustedUsers  map[string]bool
+	trustedGroups map[string]bool
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]cachedReview
+}
+
+// NewClientAuthenticator - returns the authenticator configured by the environment, nil when neither the client
+// certificates nor the bearer tokens are enabled and the admission endpoints are open to every caller
+func NewClientAuthenticator(cfg *envConfig, client kubernetes.Interface) *clientAuthenticator {
+
+	if cfg.ClientCAPath == "" && !cfg.ClientAuthTokenReview {
+		return nil
+	}
+
+	a := &clientAuthenticator{
+		audiences:     cfg.ClientAuthTokenAudiences,
+		cacheTTL:      cfg.ClientAuthCacheTTL,
+		trustedUsers:  map[string]bool{},
+		trustedGroups: map[string]bool{},
+		cache:         map[[sha256.Size]byte]cachedReview{},
+	}
+	if cfg.ClientAuthTokenReview {
+		a.client = client
+	}
+	for _, user := range cfg.TrustedClientUsers {
+		a.trustedUsers[user] = true
+	}
+	for _, group := range cfg.TrustedClientGroups {
+		a.trustedGroups[group] = true
+	}
+
+	return a
+}
+
+// LoadClientCAs - reads the PEM bundle of the CAs that sign the client certificates of the callers
+func LoadClientCAs(path string) (*x509.CertPool, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the client CA bundle - %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificate found in the client CA bundle %v", path)
+	}
+
+	return pool, nil
+}
+
+// authenticate - returns the identity of the caller, nil when the request carries no valid credentials
+func (a *clientAuthenticator) authenticate(r *http.Request) (*clientIdentity, error) {
+
+	// the TLS listener only verifies the chain, the certificate of the caller is the first of the chain
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		return &clientIdentity{Method: "client-cert", User: cert.Subject.CommonName, Groups: cert.Subject.Organization}, nil
+	}
+
+	if a.client == nil {
+		return nil, nil
+	}
+
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return nil, nil
+	}
+
+	return a.reviewToken(r.Context(), token)
+}
+
+// reviewToken - validates the token with a TokenReview, the results are cached as the API server sends the
+// same token with every admission request
+func (a *clientAuthenticator) reviewToken(ctx context.Context, token string) (*clientIdentity, error) {
+
+	key := sha256.Sum256([]byte(token))
+
+	a.mu.Lock()
+	cached, found := a.cache[key]
+	a.mu.Unlock()
+
+	if found && time.Now().Before(cached.expires) {
+		return cached.identity, nil
+	}
+
+	review, err := a.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: a.audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("TokenReview failed - %v", err)
+	}
+
+	var identity *clientIdentity
+	ttl := min(a.cacheTTL, unauthenticatedReviewTTL)
+	if review.Status.Authenticated {
+		identity = &clientIdentity{Method: "token", User: review.Status.User.Username, Groups: review.Status.User.Groups}
+		ttl = a.cacheTTL
+	}
+
+	if ttl > 0 {
+		a.store(key, cachedReview{identity: identity, expires: time.Now().Add(ttl)})
+	}
+
+	return identity, nil
+}
+
+// store - caches the result of a TokenReview, the expired results are dropped first and the ones that expire
+// first are evicted to keep at most maxCachedReviews
+func (a *clientAuthenticator) store(key [sha256.Size]byte, review cachedReview) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	for k, c := range a.cache {
+		if now.After(c.expires) {
+			delete(a.cache, k)
+		}
+	}
+
+	for len(a.cache) >= maxCachedReviews {
+		var (
+			first   [sha256.Size]byte
+			expires time.Time
+		)
+		for k, c := range a.cache {
+			if expires.IsZero() || c.expires.Before(expires) {
+				first, expires = k, c.expires
+			}
+		}
+		delete(a.cache, first)
+	}
+
+	a.cache[key] = review
+}
+
+// trusted - returns true if the caller is one of TRUSTED_CLIENT_USERS or in one of TRUSTED_CLIENT_GROUPS,
+// every authenticated caller is trusted when neither is set
+func (a *clientAuthenticator) trusted(id *clientIdentity) bool {
+
+	if len(a.trustedUsers) == 0 && len(a.trustedGroups) == 0 {
+		return true
+	}
+	if a.trustedUsers[id.User] {
+		return true
+	}
+	for _, group := range id.Groups {
+		if a.trustedGroups[group] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requireClient - rejects the requests of the callers that are not authenticated or not trusted, the
+// API server then applies the failure policy of the webhook configuration
+func (app *application) requireClient(next http.Handler) http.Handler {
+
+	if app.auth == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		id, err := app.auth.authenticate(r)
+
+		switch {
+		case err != nil:
+			clientAuthRejected.WithLabelValues("error").Inc()
+			app.writeErrorMessage(w, fmt.Sprintf("error authenticating the caller %v - %v", r.RemoteAddr, err), http.StatusServiceUnavailable)
+			return
+		case id == nil:
+			clientAuthRejected.WithLabelValues("unauthenticated").Inc()
+			app.writeErrorMessage(w, fmt.Sprintf("unauthenticated call from %v to %v", r.RemoteAddr, r.URL.Path), http.StatusUnauthorized)
+			return
+		case !app.auth.trusted(id):
+			clientAuthRejected.WithLabelValues("untrusted").Inc()
+			app.writeErrorMessage(w, fmt.Sprintf("%v %v from %v is not a trusted client", id.Method, id.User, r.RemoteAddr), http.StatusForbidden)
+			return
+		}
+
+		clientAuthAccepted.WithLabelValues(id.Method).Inc()
+		next.ServeHTTP(w, r)
+	})
+}
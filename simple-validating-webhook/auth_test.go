@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newTokenReviewClient - returns a fake clientset that authenticates the token "valid" as the API server
+func newTokenReviewClient() *fake.Clientset {
+
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch review.Spec.Token {
+		case "valid":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{
+				Username: "system:serviceaccount:kube-system:apiserver",
+				Groups:   []string{"system:serviceaccounts"},
+			}}
+		case "broken":
+			return true, nil, fmt.Errorf("the API server is not available")
+		}
+		return true, review, nil
+	})
+
+	return client
+}
+
+func TestRequireClient(t *testing.T) {
+
+	tt := []struct {
+		name           string
+		cfg            envConfig
+		cert           *x509.Certificate // verified client certificate of the connection
+		token          string
+		wantStatusCode int
+	}{
+		{
+			name:           "no authentication configured",
+			cfg:            envConfig{},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "no client certificate",
+			cfg:            envConfig{ClientCAPath: "ca.pem"},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "client certificate of any caller",
+			cfg:            envConfig{ClientCAPath: "ca.pem"},
+			cert:           &x509.Certificate{Subject: pkix.Name{CommonName: "kube-apiserver"}},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "client certificate of a trusted user",
+			cfg:            envConfig{ClientCAPath: "ca.pem", TrustedClientUsers: []string{"kube-apiserver"}},
+			cert:           &x509.Certificate{Subject: pkix.Name{CommonName: "kube-apiserver"}},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "client certificate of an untrusted user",
+			cfg:            envConfig{ClientCAPath: "ca.pem", TrustedClientUsers: []string{"kube-apiserver"}},
+			cert:           &x509.Certificate{Subject: pkix.Name{CommonName: "someone-else"}},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "client certificate in a trusted group",
+			cfg:            envConfig{ClientCAPath: "ca.pem", TrustedClientGroups: []string{"system:masters"}},
+			cert:           &x509.Certificate{Subject: pkix.Name{CommonName: "admin", Organization: []string{"system:masters"}}},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "bearer token ignored without TokenReviews",
+			cfg:            envConfig{ClientCAPath: "ca.pem"},
+			token:          "valid",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "valid bearer token",
+			cfg:            envConfig{ClientAuthTokenReview: true, TrustedClientGroups: []string{"system:serviceaccounts"}},
+			token:          "valid",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "valid bearer token of an untrusted user",
+			cfg:            envConfig{ClientAuthTokenReview: true, TrustedClientUsers: []string{"kube-apiserver"}},
+			token:          "valid",
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "invalid bearer token",
+			cfg:            envConfig{ClientAuthTokenReview: true},
+			token:          "invalid",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "TokenReview failure",
+			cfg:            envConfig{ClientAuthTokenReview: true},
+			token:          "broken",
+			wantStatusCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			app := &application{
+				errorLog: log.New(io.Discard, "", log.Ldate),
+				infoLog:  log.New(io.Discard, "", log.Ldate),
+				cfg:      &tc.cfg,
+			}
+			app.auth = NewClientAuthenticator(&tc.cfg, newTokenReviewClient())
+
+			handler := app.requireClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/validate", nil)
+			if tc.cert != nil {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tc.cert}}}
+			}
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatusCode {
+				t.Errorf("HTTP status code mismatch want=%v, got=%v - %v", tc.wantStatusCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTokenReviewsAreCached(t *testing.T) {
+
+	client := newTokenReviewClient()
+	cfg := &envConfig{ClientAuthTokenReview: true, ClientAuthCacheTTL: time.Minute}
+	auth := NewClientAuthenticator(cfg, client)
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/validate", nil)
+		r.Header.Set("Authorization", "Bearer valid")
+		if id, err := auth.authenticate(r); err != nil || id == nil {
+			t.Fatalf("authenticate() - identity=%v, err=%v", id, err)
+		}
+	}
+
+	if got := len(client.Actions()); got != 1 {
+		t.Errorf("TokenReviews created for the same token - got=%v, want=1", got)
+	}
+
+	// the rejected tokens expire sooner and the cache is bounded
+	r := httptest.NewRequest(http.MethodPost, "/validate", nil)
+	r.Header.Set("Authorization", "Bearer rejected")
+	if id, err := auth.authenticate(r); err != nil || id != nil {
+		t.Fatalf("authenticate() of a rejected token - identity=%v, err=%v", id, err)
+	}
+	for _, review := range auth.cache {
+		if review.identity == nil && time.Until(review.expires) > unauthenticatedReviewTTL {
+			t.Errorf("rejected token cached for %v, want at most %v", time.Until(review.expires), unauthenticatedReviewTTL)
+		}
+	}
+
+	for i := 0; i < maxCachedReviews+10; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/validate", nil)
+		r.Header.Set("Authorization", fmt.Sprintf("Bearer token-%d", i))
+		if _, err := auth.authenticate(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(auth.cache); got > maxCachedReviews {
+		t.Errorf("cached TokenReviews - got=%v, want at most %v", got, maxCachedReviews)
+	}
+}
+
+func TestAdmissionRoutesRequireClient(t *testing.T) {
+
+	cfg := &envConfig{ClientCAPath: "ca.pem"}
+	app := &application{
+		errorLog: log.New(io.Discard, "", log.Ldate),
+		infoLog:  log.New(io.Discard, "", log.Ldate),
+		cfg:      cfg,
+	}
+	app.auth = NewClientAuthenticator(cfg, nil)
+
+	srv := httptest.NewServer(app.setupRoutes())
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/validate", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthenticated POST /validate - got=%v, want=%v", res.StatusCode, http.StatusUnauthorized)
+	}
+
+	// the probes of the kubelet do not authenticate
+	res, err = http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("unauthenticated GET /healthz - got=%v, want=%v", res.StatusCode, http.StatusOK)
+	}
+}
+
+func TestLoadClientCAs(t *testing.T) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(valid, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalid, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadClientCAs(valid); err != nil {
+		t.Errorf("LoadClientCAs() unexpected error - %v", err)
+	}
+	if _, err := LoadClientCAs(invalid); err == nil {
+		t.Errorf("LoadClientCAs() accepted a file without certificates")
+	}
+	if _, err := LoadClientCAs(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Errorf("LoadClientCAs() accepted a missing file")
+	}
+}
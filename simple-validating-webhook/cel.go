@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"go.opentelemetry.io/otel/attribute"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	"simple-validating-webhook/webhook"
+)
+
+// celCostLimit - upper bound of the runtime cost of a single CEL rule evaluation
+const celCostLimit = 1000000
+
+// celInterruptCheckFrequency - comprehension iterations between the checks of the request deadline
+const celInterruptCheckFrequency = 100
+
+// celRule is a policy rule written as a CEL expression that must evaluate to true for the object to be allowed
+type celRule struct {
+	Name       string   `json:"name"`
+	Expression string   `json:"expression"`
+	Message    string   `json:"message,omitempty"`
+	Kinds      []string `json:"kinds,omitempty"` // kinds the rule applies to, defaults to Pod
+
+	program cel.Program
+}
+
+// celRulesFile is the format of the CEL rules file, e.g.
+//
+//	rules:
+//	  - name: owner-prefix
+//	    expression: "object.metadata.labels.owner.matches('^team-')"
+//	    message: "the owner label must start with team-"
+type celRulesFile struct {
+	Rules []celRule `json:"rules"`
+}
+
+// newCELEnv - returns the CEL environment with the variables bound for every admission request
+func newCELEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.Variable("oldObject", cel.DynType),
+		cel.Variable("namespaceObject", cel.DynType),
+		cel.Variable("request", cel.DynType),
+		cel.Variable("userInfo", cel.DynType),
+		ext.Strings(),
+	)
+}
+
+// LoadCELRules - reads the CEL rules file and compiles and type-checks every rule, returns
+// an error naming the rule for the first rule that does not compile or does not evaluate to bool
+func LoadCELRules(path string) ([]celRule, error) {
+
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CEL rules file %v - %v", path, err)
+	}
+
+	return parseCELRules(data)
+}
+
+// parseCELRules - parses and compiles the content of a CEL rules file
+func parseCELRules(data []byte) ([]celRule, error) {
+
+	var file celRulesFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing CEL rules - %v", err)
+	}
+
+	env, err := newCELEnv()
+	if err != nil {
+		return nil, fmt.Errorf("error creating CEL environment - %v", err)
+	}
+
+	names := map[string]bool{}
+	for i := range file.Rules {
+		rule := &file.Rules[i]
+
+		if rule.Name == "" {
+			return nil, fmt.Errorf("CEL rule %d has no name", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("CEL rule %q is defined more than once", rule.Name)
+		}
+		names[rule.Name] = true
+
+		ast, iss := env.Compile(rule.Expression)
+		if iss.Err() != nil {
+			return nil, fmt.Errorf("CEL rule %q does not compile:\n%v", rule.Name, iss.Err())
+		}
+
+		// a field of the object is dyn, its type is only known when the rule is evaluated and a value that is
+		// not a bool denies the request
+		if t := ast.OutputType(); !t.IsExactType(cel.BoolType) && !t.IsExactType(cel.DynType) {
+			return nil, fmt.Errorf("CEL rule %q must evaluate to bool, got %v", rule.Name, t)
+		}
+
+		rule.program, err = env.Program(ast, cel.CostLimit(celCostLimit), cel.InterruptCheckFrequency(celInterruptCheckFrequency))
+		if err != nil {
+			return nil, fmt.Errorf("CEL rule %q can not be planned - %v", rule.Name, err)
+		}
+
+		if len(rule.Kinds) == 0 {
+			rule.Kinds = []string{"Pod"}
+		}
+	}
+
+	return file.Rules, nil
+}
+
+// appliesTo - returns true if the rule has to be evaluated for objects of the kind
+func (r *celRule) appliesTo(kind string) bool {
+	for _, k := range r.Kinds {
+		if strings.EqualFold(k, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// celVariables - converts the admission request and the namespace of the object into the CEL variables
+func celVariables(req *admissionv1.AdmissionRequest, ns *corev1.Namespace) (map[string]interface{}, error) {
+
+	vars := map[string]interface{}{
+		"object":          nil,
+		"oldObject":       nil,
+		"namespaceObject": nil,
+	}
+
+	for name, raw := range map[string]runtime.RawExtension{"object": req.Object, "oldObject": req.OldObject} {
+		if len(raw.Raw) == 0 {
+			continue
+		}
+		obj := map[string]interface{}{}
+		if err := json.Unmarshal(raw.Raw, &obj); err != nil {
+			return nil, fmt.Errorf("error decoding %v - %v", name, err)
+		}
+		vars[name] = obj
+	}
+
+	if ns != nil {
+		nsObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(ns)
+		if err != nil {
+			return nil, fmt.Errorf("error converting the namespace %v - %v", ns.Name, err)
+		}
+		vars["namespaceObject"] = nsObj
+	}
+
+	request, err := toJSONMap(req)
+	if err != nil {
+		return nil, fmt.Errorf("error converting the request - %v", err)
+	}
+	// the objects are already bound to their own variables
+	delete(request, "object")
+	delete(request, "oldObject")
+	vars["request"] = request
+
+	userInfo, err := toJSONMap(req.UserInfo)
+	if err != nil {
+		return nil, fmt.Errorf("error converting the user info - %v", err)
+	}
+	vars["userInfo"] = userInfo
+
+	return vars, nil
+}
+
+// toJSONMap - converts a value into its generic JSON representation
+func toJSONMap(v interface{}) (map[string]interface{}, error) {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// evaluateCELRules - evaluates the CEL rules that apply to the kind of the object, a rule that returns
+// false or fails to evaluate, e.g. because a field is missing, is a violation
+func (a *application) evaluateCELRules(ctx context.Context, req *admissionv1.AdmissionRequest, ns *corev1.Namespace) webhook.Violations {
+
+	kind := req.Kind.Kind
+
+	var rules []*celRule
+	for i := range a.celRules {
+		if a.celRules[i].appliesTo(kind) {
+			rules = append(rules, &a.celRules[i])
+		}
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	vars, err := celVariables(req, ns)
+	if err != nil {
+		return webhook.Violations{{Rule: "cel", Message: "Denied because the CEL rules could not be evaluated - " + err.Error()}}
+	}
+
+	var violations webhook.Violations
+
+	for _, rule := range rules {
+
+		ruleCtx, span := webhook.StartSpan(ctx, "cel rule", attribute.String("cel.rule", rule.Name))
+		out, _, err := rule.program.ContextEval(ruleCtx, vars)
+		allowed := false
+		if err == nil {
+			allowed, _ = out.Value().(bool)
+			span.SetAttributes(attribute.Bool("cel.allowed", allowed))
+		}
+		webhook.EndSpan(span, err)
+
+		if err != nil {
+			webhook.Logf(ctx, a.errorLog, "error evaluating CEL rule %v on %v %v/%v - %v", rule.Name, kind, req.Namespace, req.Name, err)
+			violations = append(violations, webhook.Violation{
+				Rule:    "cel:" + rule.Name,
+				Message: fmt.Sprintf("Denied because the CEL rule %v could not be evaluated - %v", rule.Name, err),
+			})
+			continue
+		}
+
+		if allowed {
+			continue
+		}
+
+		msg := rule.Message
+		if msg == "" {
+			msg = fmt.Sprintf("Denied because the CEL rule %v failed: %v", rule.Name, rule.Expression)
+		}
+
+		violations = append(violations, webhook.Violation{Rule: "cel:" + rule.Name, Message: msg})
+	}
+
+	return violations
+}
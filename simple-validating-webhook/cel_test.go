@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseCELRules(t *testing.T) {
+
+	tt := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "valid rules",
+			content: `
+rules:
+  - name: owner-prefix
+    expression: "object.metadata.labels.owner.matches('^team-')"
+  - name: limits
+    expression: "object.spec.containers.all(c, has(c.resources.limits))"
+`,
+		},
+		{
+			name:    "dyn field of the object",
+			content: "rules:\n  - name: host-network\n    expression: \"object.spec.hostNetwork\"\n",
+		},
+		{
+			name:    "syntax error",
+			content: "rules:\n  - name: broken\n    expression: \"object.metadata.labels.owner.matches(\"\n",
+			wantErr: `CEL rule "broken" does not compile`,
+		},
+		{
+			name:    "expression does not return bool",
+			content: "rules:\n  - name: not-bool\n    expression: \"size(object.metadata.labels)\"\n",
+			wantErr: `CEL rule "not-bool" must evaluate to bool`,
+		},
+		{
+			name:    "undeclared variable",
+			content: "rules:\n  - name: unknown\n    expression: \"pod.metadata.name == 'x'\"\n",
+			wantErr: `CEL rule "unknown" does not compile`,
+		},
+		{
+			name:    "duplicate rule name",
+			content: "rules:\n  - name: a\n    expression: \"true\"\n  - name: a\n    expression: \"true\"\n",
+			wantErr: `CEL rule "a" is defined more than once`,
+		},
+		{
+			name:    "unknown field",
+			content: "rules:\n  - name: a\n    expresion: \"true\"\n",
+			wantErr: "error parsing CEL rules",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+
+			_, err := parseCELRules([]byte(tc.content))
+
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parseCELRules() unexpected error = %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("parseCELRules() error = %v, want error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluateCELRules(t *testing.T) {
+
+	rules, err := parseCELRules([]byte(`
+rules:
+  - name: app-label
+    expression: "object.metadata.labels.app == 'busybox1'"
+  - name: owner-prefix
+    expression: "object.metadata.labels.owner.matches('^team-')"
+    message: "the owner label must start with team-"
+  - name: limits
+    expression: "object.spec.containers.all(c, has(c.resources.limits))"
+  - name: namespace-env
+    expression: "namespaceObject.metadata.labels.env == 'prod' && userInfo.username == request.userInfo.username"
+  - name: namespaces-only
+    kinds: [Namespace]
+    expression: "false"
+  - name: service-links
+    expression: "object.spec.enableServiceLinks"
+  - name: restart-policy
+    expression: "object.spec.restartPolicy"   # a string, denies like false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name           string
+		sourceJsonFile string
+		wantRules      []string
+	}{
+		{
+			name:           "Pod without owner label and limits",
+			sourceJsonFile: "test-files/admission-request-missing-labels.json",
+			wantRules:      []string{"cel:owner-prefix/", "cel:limits/", "cel:restart-policy/"},
+		},
+		{
+			name:           "Pod with owner label but without limits",
+			sourceJsonFile: "test-files/admission-request-with-labels.json",
+			wantRules:      []string{"cel:owner-prefix/", "cel:limits/", "cel:restart-policy/"},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+
+			data, err := os.ReadFile(tc.sourceJsonFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			review := admissionv1.AdmissionReview{}
+			if err := json.Unmarshal(data, &review); err != nil {
+				t.Fatal(err)
+			}
+
+			app := &application{
+				errorLog: log.New(io.Discard, "", log.Ldate),
+				infoLog:  log.New(io.Discard, "", log.Ldate),
+				cfg:      &envConfig{},
+				celRules: rules,
+			}
+
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook-demo", Labels: map[string]string{"env": "prod"}},
+			}
+
+			violations := app.evaluateCELRules(context.Background(), review.Request, ns)
+
+			if got := violations.Rules(); !reflect.DeepEqual(got, tc.wantRules) {
+				t.Errorf("evaluateCELRules() failed rules - got=%v, want=%v", got, tc.wantRules)
+			}
+		})
+	}
+}
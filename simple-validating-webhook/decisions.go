@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"simple-validating-webhook/webhook"
+)
+
+// decisionsBucket - bbolt bucket of the decisions, keyed by time so that a cursor walks them in order
+var decisionsBucket = []byte("decisions")
+
+// page sizes of /api/decisions
+const (
+	defaultDecisionsLimit = 100
+	maxDecisionsLimit     = 1000
+)
+
+// decisionRecord is an admission decision kept in the decision history
+type decisionRecord struct {
+	Time       time.Time          `json:"time"`
+	UID        string             `json:"uid"`
+	User       string             `json:"user"`
+	Operation  string             `json:"operation"`
+	Namespace  string             `json:"namespace,omitempty"`
+	Kind       string             `json:"kind"`
+	Name       string             `json:"name,omitempty"`
+	Decision   string             `json:"decision"` // allowed or denied
+	Message    string             `json:"message,omitempty"`
+	Violations webhook.Violations `json:"violations,omitempty"`
+}
+
+// decisionFilter selects the decisions returned by a query, empty fields match every decision
+type decisionFilter struct {
+	Namespace string
+	Decision  string
+	Rule      string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+	Continue  string // key of the last decision of the previous page
+}
+
+// decisionStore is the decision history in an embedded bbolt database
+type decisionStore struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+// OpenDecisionStore - opens or creates the decision history database, decisions older than
+// the retention are removed by prune
+func OpenDecisionStore(path string, retention time.Duration) (*decisionStore, error) {
+
+	if retention <= 0 {
+		return nil, fmt.Errorf("invalid decision retention %v, must be positive", retention)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening the decision history %v - %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(decisionsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("error creating the decision history bucket - %v", err)
+	}
+
+	return &decisionStore{db: db, retention: retention}, nil
+}
+
+// Close - closes the database
+func (s *decisionStore) Close() error {
+	return s.db.Close()
+}
+
+// decisionKey - returns the key of a decision, the big-endian time sorts the keys by time and
+// the UID keeps the keys of decisions made at the same time apart
+func decisionKey(t time.Time, uid string) []byte {
+
+	key := make([]byte, 8, 8+len(uid))
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+
+	return append(key, uid...)
+}
+
+// timeKey - returns the smallest key of the decisions made at t
+func timeKey(t time.Time) []byte {
+	return decisionKey(t, "")
+}
+
+// record - stores a decision, the decisions of concurrent requests are committed together in one
+// transaction instead of one fsync per request
+func (s *decisionStore) record(rec decisionRecord) error {
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	// Batch may run the function more than once, Put of the same key is idempotent
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(decisionsBucket).Put(decisionKey(rec.Time, rec.UID), data)
+	})
+}
+
+// prune - removes the decisions older than the retention, returns the number of removed decisions
+func (s *decisionStore) prune(now time.Time) (int, error) {
+
+	cutoff := timeKey(now.Add(-s.retention))
+	removed := 0
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(decisionsBucket).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, cutoff) < 0; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+
+	return removed, err
+}
+
+// watch - prunes the decisions every interval until the context is cancelled
+func (s *decisionStore) watch(ctx context.Context, interval time.Duration, infoLog, errorLog *log.Logger) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			removed, err := s.prune(now)
+			if err != nil {
+				errorLog.Printf("error pruning the decision history - %v", err)
+				continue
+			}
+			if removed > 0 {
+				infoLog.Printf("Removed %d decisions older than %v from the decision history", removed, s.retention)
+			}
+		}
+	}
+}
+
+// matches - returns true if the decision is selected by the filter
+func (f decisionFilter) matches(rec decisionRecord) bool {
+
+	if f.Namespace != "" && rec.Namespace != f.Namespace {
+		return false
+	}
+
+	if f.Decision != "" && rec.Decision != f.Decision {
+		return false
+	}
+
+	if f.Rule == "" {
+		return true
+	}
+
+	for _, v := range rec.Violations {
+		if v.Rule == f.Rule {
+			return true
+		}
+	}
+
+	return false
+}
+
+// query - returns the decisions selected by the filter, newest first, and the continue token of the
+// next page, which is empty on the last page
+func (s *decisionStore) query(f decisionFilter) ([]decisionRecord, string, error) {
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defaultDecisionsLimit
+	}
+
+	// the keys of a page are below the exclusive upper bound, which is the key of the last decision
+	// of the previous page or the end of the until time
+	var upper []byte
+	if f.Continue != "" {
+		key, err := hex.DecodeString(f.Continue)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid continue token %q", f.Continue)
+		}
+		upper = key
+	}
+	if !f.Until.IsZero() {
+		if until := timeKey(f.Until.Add(time.Nanosecond)); upper == nil || bytes.Compare(until, upper) < 0 {
+			upper = until
+		}
+	}
+
+	var lower []byte
+	if !f.Since.IsZero() {
+		lower = timeKey(f.Since)
+	}
+
+	records := []decisionRecord{}
+	next := ""
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+
+		c := tx.Bucket(decisionsBucket).Cursor()
+
+		// position the cursor on the newest decision below the upper bound
+		var k, v []byte
+		if upper == nil {
+			k, v = c.Last()
+		} else if k, v = c.Seek(upper); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+
+		for ; k != nil; k, v = c.Prev() {
+
+			if lower != nil && bytes.Compare(k, lower) < 0 {
+				return nil
+			}
+
+			var rec decisionRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("invalid decision %x - %v", k, err)
+			}
+
+			if !f.matches(rec) {
+				continue
+			}
+
+			if len(records) == limit {
+				next = hex.EncodeToString(records[len(records)-1].key())
+				return nil
+			}
+
+			records = append(records, rec)
+		}
+
+		return nil
+	})
+
+	return records, next, err
+}
+
+// key - returns the key of the decision in the store
+func (rec decisionRecord) key() []byte {
+	return decisionKey(rec.Time, rec.UID)
+}
+
+// recordDecision - side effect that stores the decision of a request in the decision history
+func (app *application) recordDecision(ctx context.Context, req *webhook.Request, result webhook.Result) {
+
+	rec := decisionRecord{
+		Time:       time.Now().UTC(),
+		UID:        string(req.UID),
+		User:       req.UserInfo.Username,
+		Operation:  string(req.Operation),
+		Namespace:  req.Namespace,
+		Kind:       req.Kind.Kind,
+		Name:       req.Name,
+		Decision:   "allowed",
+		Message:    result.Message,
+		Violations: result.Violations,
+	}
+
+	if !result.Allowed() {
+		rec.Decision, rec.Message = "denied", result.Violations.Messages()
+	}
+
+	if err := app.decisions.record(rec); err != nil {
+		webhook.Logf(ctx, app.errorLog, "error recording the decision of %v %v/%v - %v", rec.Kind, rec.Namespace, rec.Name, err)
+	}
+}
+
+// parseDecisionFilter - reads the filter from the query parameters namespace, decision, rule, since,
+// until (RFC 3339), limit and continue
+func parseDecisionFilter(r *http.Request) (decisionFilter, error) {
+
+	q := r.URL.Query()
+
+	f := decisionFilter{
+		Namespace: q.Get("namespace"),
+		Decision:  q.Get("decision"),
+		Rule:      q.Get("rule"),
+		Continue:  q.Get("continue"),
+	}
+
+	if _, err := hex.DecodeString(f.Continue); err != nil {
+		return f, fmt.Errorf("invalid continue token %q", f.Continue)
+	}
+
+	if f.Decision != "" && f.Decision != "allowed" && f.Decision != "denied" {
+		return f, fmt.Errorf("invalid decision %q, must be allowed or denied", f.Decision)
+	}
+
+	for name, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if value := q.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return f, fmt.Errorf("invalid %v %q, must be an RFC 3339 time - %v", name, value, err)
+			}
+			*t = parsed
+		}
+	}
+
+	if value := q.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxDecisionsLimit {
+			return f, fmt.Errorf("invalid limit %q, must be between 1 and %d", value, maxDecisionsLimit)
+		}
+		f.Limit = limit
+	}
+
+	return f, nil
+}
+
+// listDecisions - returns the decisions of the decision history, e.g.
+// GET /api/decisions?namespace=team-a&decision=denied&since=2024-01-01T00:00:00Z&limit=50
+func (app *application) listDecisions(w http.ResponseWriter, r *http.Request) {
+
+	f, err := parseDecisionFilter(r)
+	if err != nil {
+		app.writeErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, next, err := app.decisions.query(f)
+	if err != nil {
+		app.writeErrorMessage(w, "Unable to query the decision history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"items":    records,
+		"continue": next,
+	})
+}
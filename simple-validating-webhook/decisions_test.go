@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"simple-validating-webhook/webhook"
+)
+
+// newTestDecisionStore - returns a decision history in a temporary directory
+func newTestDecisionStore(t *testing.T) *decisionStore {
+
+	store, err := OpenDecisionStore(filepath.Join(t.TempDir(), "decisions.db"), 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	return store
+}
+
+// seedDecisions - records a decision per minute, alternating the namespace and the decision
+func seedDecisions(t *testing.T, store *decisionStore, start time.Time, count int) {
+
+	for i := 0; i < count; i++ {
+
+		rec := decisionRecord{
+			Time:      start.Add(time.Duration(i) * time.Minute),
+			UID:       fmt.Sprintf("uid-%02d", i),
+			Namespace: []string{"team-a", "team-b"}[i%2],
+			Kind:      "Pod",
+			Decision:  "allowed",
+		}
+		if i%3 == 0 {
+			rec.Decision = "denied"
+			rec.Violations = webhook.Violations{{Rule: "missing-label", Message: "missing owner"}}
+		}
+
+		if err := store.record(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDecisionStoreQuery(t *testing.T) {
+
+	store := newTestDecisionStore(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	seedDecisions(t, store, start, 10)
+
+	tt := []struct {
+		name    string
+		filter  decisionFilter
+		wantUID []string
+	}{
+		{
+			name:    "newest first",
+			filter:  decisionFilter{Limit: 3},
+			wantUID: []string{"uid-09", "uid-08", "uid-07"},
+		},
+		{
+			name:    "namespace and decision",
+			filter:  decisionFilter{Namespace: "team-a", Decision: "denied"},
+			wantUID: []string{"uid-06", "uid-00"},
+		},
+		{
+			name:    "rule",
+			filter:  decisionFilter{Rule: "missing-label"},
+			wantUID: []string{"uid-09", "uid-06", "uid-03", "uid-00"},
+		},
+		{
+			name:    "since and until are inclusive",
+			filter:  decisionFilter{Since: start.Add(2 * time.Minute), Until: start.Add(4 * time.Minute)},
+			wantUID: []string{"uid-04", "uid-03", "uid-02"},
+		},
+		{
+			name:    "no match",
+			filter:  decisionFilter{Namespace: "team-c"},
+			wantUID: []string{},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			records, _, err := store.query(tc.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, rec := range records {
+				got = append(got, rec.UID)
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tc.wantUID) {
+				t.Errorf("query() - got=%v, want=%v", got, tc.wantUID)
+			}
+		})
+	}
+}
+
+func TestDecisionStorePagination(t *testing.T) {
+
+	store := newTestDecisionStore(t)
+	seedDecisions(t, store, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 10)
+
+	var got []string
+	f := decisionFilter{Namespace: "team-b", Limit: 2}
+
+	for pages := 1; ; pages++ {
+
+		records, next, err := store.query(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range records {
+			got = append(got, rec.UID)
+		}
+
+		if next == "" {
+			if pages != 3 {
+				t.Errorf("pages - got=%v, want=3", pages)
+			}
+			break
+		}
+		f.Continue = next
+	}
+
+	want := []string{"uid-09", "uid-07", "uid-05", "uid-03", "uid-01"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("paginated query - got=%v, want=%v", got, want)
+	}
+}
+
+func TestDecisionStorePrune(t *testing.T) {
+
+	store := newTestDecisionStore(t)
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	// 4 decisions older than the retention of 24h and 6 within it
+	seedDecisions(t, store, now.Add(-24*time.Hour-4*time.Minute), 10)
+
+	removed, err := store.prune(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 4 {
+		t.Errorf("prune() removed - got=%v, want=4", removed)
+	}
+
+	records, _, err := store.query(decisionFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 6 || records[len(records)-1].UID != "uid-04" {
+		t.Errorf("decisions after prune - got=%v, oldest=%v", len(records), records[len(records)-1].UID)
+	}
+}
+
+// TestRecordDecisions - the webhook records its decisions, except for dry-run requests, and
+// /api/decisions of the admin port returns them
+func TestRecordDecisions(t *testing.T) {
+
+	client := fake.NewSimpleClientset()
+	CreateNamespace(t, "webhook-demo", map[string]string{"example.com/validate": "true"}, client)
+
+	app := &application{
+		errorLog:  log.New(io.Discard, "", log.Ldate),
+		infoLog:   log.New(io.Discard, "", log.Ldate),
+		cfg:       &envConfig{Annotation: "example.com/validate", Label: "owner"},
+		client:    client,
+		decisions: newTestDecisionStore(t),
+	}
+
+	router := app.setupRoutes()
+	srv := httptest.NewServer(app.setupAdminRoutes())
+	defer srv.Close()
+
+	// without client authentication the history is not served on the TLS port
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/decisions", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("/api/decisions of the TLS port without client authentication - got=%v, want=%v", rr.Code, http.StatusNotFound)
+	}
+
+	// the recorder returns once the side effects ran, a client gets the response before them
+	for _, file := range []string{
+		"test-files/admission-request-missing-labels.json",
+		"test-files/admission-request-missing-labels-dry-run.json",
+		"test-files/admission-request-with-labels.json",
+	} {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/validate", f))
+		f.Close()
+	}
+
+	tt := []struct {
+		name       string
+		query      string
+		statusCode int
+		wantItems  []string
+	}{
+		{name: "all decisions", query: "", statusCode: http.StatusOK, wantItems: []string{"allowed", "denied"}},
+		{name: "denied decisions", query: "?decision=denied", statusCode: http.StatusOK, wantItems: []string{"denied"}},
+		{name: "other namespace", query: "?namespace=default", statusCode: http.StatusOK, wantItems: []string{}},
+		{name: "invalid decision", query: "?decision=maybe", statusCode: http.StatusBadRequest},
+		{name: "invalid since", query: "?since=yesterday", statusCode: http.StatusBadRequest},
+		{name: "invalid limit", query: "?limit=5000", statusCode: http.StatusBadRequest},
+		{name: "invalid continue", query: "?continue=zz", statusCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			res, err := http.Get(srv.URL + "/api/decisions" + tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != tc.statusCode {
+				t.Fatalf("HTTP status code mismatch want=%v, got=%v", tc.statusCode, res.StatusCode)
+			}
+
+			if res.StatusCode != http.StatusOK {
+				return
+			}
+
+			var page struct {
+				Items    []decisionRecord `json:"items"`
+				Continue string           `json:"continue"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, rec := range page.Items {
+				got = append(got, rec.Decision)
+				if rec.Namespace != "webhook-demo" || rec.Kind != "Pod" || rec.UID == "" {
+					t.Errorf("unexpected decision %+v", rec)
+				}
+				if rec.Decision == "denied" && (len(rec.Violations) == 0 || rec.Message == "") {
+					t.Errorf("denied decision without violations %+v", rec)
+				}
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tc.wantItems) {
+				t.Errorf("decisions - got=%v, want=%v", got, tc.wantItems)
+			}
+		})
+	}
+}
+
+// TestDecisionsRouteWithClientAuth - with client authentication the history is served on the TLS port
+// to the authenticated callers only, and not on the admin port
+func TestDecisionsRouteWithClientAuth(t *testing.T) {
+
+	cfg := &envConfig{Annotation: "example.com/validate", Label: "owner", ClientCAPath: "ca.pem"}
+	app := &application{
+		errorLog:  log.New(io.Discard, "", log.Ldate),
+		infoLog:   log.New(io.Discard, "", log.Ldate),
+		cfg:       cfg,
+		client:    fake.NewSimpleClientset(),
+		decisions: newTestDecisionStore(t),
+		auth:      NewClientAuthenticator(cfg, nil),
+	}
+
+	for _, tc := range []struct {
+		name       string
+		router     http.Handler
+		statusCode int
+	}{
+		{name: "TLS port", router: app.setupRoutes(), statusCode: http.StatusUnauthorized},
+		{name: "admin port", router: app.setupAdminRoutes(), statusCode: http.StatusNotFound},
+	} {
+		rr := httptest.NewRecorder()
+		tc.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/decisions", nil))
+		if rr.Code != tc.statusCode {
+			t.Errorf("%v - HTTP status code mismatch want=%v, got=%v", tc.name, tc.statusCode, rr.Code)
+		}
+	}
+}
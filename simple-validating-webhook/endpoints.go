@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"sigs.k8s.io/yaml"
+
+	"simple-validating-webhook/webhook"
+)
+
+// names of the built-in validators that can be selected by an endpoint
+const (
+	validatorOwnerLabel = "owner-label"
+	validatorNamespace  = "namespace"
+	validatorCEL        = "cel"
+	validatorRego       = "rego"
+	validatorWASM       = "wasm"
+)
+
+// allValidators - the validators of an endpoint that does not select any, in the order they run
+var allValidators = []string{validatorOwnerLabel, validatorNamespace, validatorCEL, validatorRego, validatorWASM}
+
+// endpointConfig is a webhook endpoint in the endpoints file, every field that is not set
+// is inherited from the environment variables
+type endpointConfig struct {
+	Path       string   `json:"path"`
+	Validators []string `json:"validators,omitempty"` // defaults to all the validators
+
+	Label                        string   `json:"label,omitempty"`
+	Annotation                   string   `json:"annotation,omitempty"`
+	NamespaceMode                string   `json:"namespaceMode,omitempty"`
+	NamespaceSelector            string   `json:"namespaceSelector,omitempty"`
+	NamespaceRequiredLabels      []string `json:"namespaceRequiredLabels,omitempty"`
+	NamespaceRequiredAnnotations []string `json:"namespaceRequiredAnnotations,omitempty"`
+	CELRulesPath                 string   `json:"celRulesPath,omitempty"`
+	FailurePolicy                string   `json:"failurePolicy,omitempty"`
+
+	ExemptNamespaces []string `json:"exemptNamespaces,omitempty"`
+	ExemptUsers      []string `json:"exemptUsers,omitempty"`
+	ExemptGroups     []string `json:"exemptGroups,omitempty"`
+}
+
+// endpointsFile is the format of the endpoints file, e.g.
+//
+//	endpoints:
+//	  - path: /validate/pods
+//	    validators: [owner-label, cel]
+//	    namespaceMode: opt-out
+//	    exemptNamespaces: [kube-system]
+//	  - path: /validate/namespaces
+//	    validators: [namespace]
+type endpointsFile struct {
+	Endpoints []endpointConfig `json:"endpoints"`
+}
+
+// endpoint is a path served with its own configuration and validators
+type endpoint struct {
+	path       string
+	app        *application // copy of the application with the settings of the endpoint
+	validators []string
+	exemptions exemptions
+}
+
+// exemptions are the namespaces and users whose requests are always allowed by an endpoint
+type exemptions struct {
+	namespaces map[string]bool
+	users      map[string]bool
+	groups     map[string]bool
+}
+
+// LoadEndpointsFile - reads the endpoints file, returns no endpoints when the path is empty
+func LoadEndpointsFile(path string) ([]endpointConfig, error) {
+
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading endpoints file %v - %v", path, err)
+	}
+
+	return parseEndpoints(data)
+}
+
+// parseEndpoints - parses the content of an endpoints file and checks the paths and validator names
+func parseEndpoints(data []byte) ([]endpointConfig, error) {
+
+	var file endpointsFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing endpoints - %v", err)
+	}
+
+	if len(file.Endpoints) == 0 {
+		return nil, fmt.Errorf("endpoints file has no endpoints")
+	}
+
+	known := map[string]bool{}
+	for _, name := range allValidators {
+		known[name] = true
+	}
+
+	paths := map[string]bool{}
+	for i, ep := range file.Endpoints {
+
+		if !strings.HasPrefix(ep.Path, "/") {
+			return nil, fmt.Errorf("endpoint %d has an invalid path %q, it must start with /", i, ep.Path)
+		}
+		if paths[ep.Path] {
+			return nil, fmt.Errorf("endpoint %q is defined more than once", ep.Path)
+		}
+		paths[ep.Path] = true
+
+		for _, name := range ep.Validators {
+			if !known[name] {
+				return nil, fmt.Errorf("endpoint %q has an unknown validator %q, must be one of %v", ep.Path, name,
+					strings.Join(allValidators, ", "))
+			}
+		}
+	}
+
+	return file.Endpoints, nil
+}
+
+// newEndpoint - returns the endpoint with a copy of the application that has the settings of the
+// endpoint applied on top of the environment variables
+func (app *application) newEndpoint(ec endpointConfig) (*endpoint, error) {
+
+	cfg := *app.cfg
+	epApp := *app
+	epApp.cfg = &cfg
+
+	if ec.Label != "" {
+		cfg.Label = ec.Label
+	}
+	if ec.Annotation != "" {
+		cfg.Annotation = ec.Annotation
+	}
+	if ec.NamespaceMode != "" {
+		if err := ValidateNamespaceMode(ec.NamespaceMode); err != nil {
+			return nil, fmt.Errorf("endpoint %q - %v", ec.Path, err)
+		}
+		cfg.NamespaceMode = ec.NamespaceMode
+	}
+	if ec.NamespaceSelector != "" {
+		selector, err := ParseNamespaceSelector(ec.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %q - %v", ec.Path, err)
+		}
+		cfg.NamespaceSelector, epApp.selector = ec.NamespaceSelector, selector
+	}
+	if ec.NamespaceRequiredLabels != nil {
+		cfg.NamespaceRequiredLabels = ec.NamespaceRequiredLabels
+	}
+	if ec.NamespaceRequiredAnnotations != nil {
+		cfg.NamespaceRequiredAnnotations = ec.NamespaceRequiredAnnotations
+	}
+	if ec.FailurePolicy != "" {
+		if err := ValidateFailurePolicy(ec.FailurePolicy); err != nil {
+			return nil, fmt.Errorf("endpoint %q - %v", ec.Path, err)
+		}
+		cfg.FailurePolicy = ec.FailurePolicy
+	}
+	if ec.CELRulesPath != "" {
+		rules, err := LoadCELRules(ec.CELRulesPath)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %q - %v", ec.Path, err)
+		}
+		cfg.CELRulesPath, epApp.celRules = ec.CELRulesPath, rules
+	}
+
+	validators := ec.Validators
+	if len(validators) == 0 {
+		validators = allValidators
+	}
+
+	return &endpoint{
+		path:       ec.Path,
+		app:        &epApp,
+		validators: validators,
+		exemptions: exemptions{
+			namespaces: toSet(ec.ExemptNamespaces),
+			users:      toSet(ec.ExemptUsers),
+			groups:     toSet(ec.ExemptGroups),
+		},
+	}, nil
+}
+
+// LoadEndpoints - builds the endpoints of the endpoints file, returns no endpoints when the path
+// is empty and the single /validate endpoint is served with all the validators
+func (app *application) LoadEndpoints(path string) ([]*endpoint, error) {
+
+	configs, err := LoadEndpointsFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]*endpoint, 0, len(configs))
+	for _, ec := range configs {
+		ep, err := app.newEndpoint(ec)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, ep)
+	}
+
+	return endpoints, nil
+}
+
+// server - returns the admission server of the endpoint
+func (ep *endpoint) server() *webhook.Server {
+	return ep.app.newWebhookServer(ep.exemptions, ep.validators...)
+}
+
+// toSet - converts a list into a set, returns nil for an empty list
+func toSet(values []string) map[string]bool {
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+
+	return set
+}
+
+// exemptReason - returns why the request is exempt from the validation, or an empty string
+func (e exemptions) exemptReason(namespace string, user authenticationv1.UserInfo) string {
+
+	if namespace != "" && e.namespaces[namespace] {
+		return "skipping validation as the namespace " + namespace + " is exempt"
+	}
+
+	if e.users[user.Username] {
+		return "skipping validation as the user " + user.Username + " is exempt"
+	}
+
+	for _, group := range user.Groups {
+		if e.groups[group] {
+			return "skipping validation as the group " + group + " is exempt"
+		}
+	}
+
+	return ""
+}
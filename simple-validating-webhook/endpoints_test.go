@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseEndpoints(t *testing.T) {
+
+	tt := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{
+			name: "valid endpoints",
+			data: `
+endpoints:
+  - path: /validate/pods
+    validators: [owner-label, cel]
+  - path: /validate/namespaces
+    validators: [namespace]
+`,
+		},
+		{name: "no endpoints", data: `endpoints: []`, wantErr: "has no endpoints"},
+		{name: "relative path", data: `endpoints: [{path: validate}]`, wantErr: "must start with /"},
+		{name: "duplicate path", data: `endpoints: [{path: /a}, {path: /a}]`, wantErr: "more than once"},
+		{name: "unknown validator", data: `endpoints: [{path: /a, validators: [foo]}]`, wantErr: "unknown validator"},
+		{name: "unknown field", data: `endpoints: [{path: /a, timeoutSeconds: 5}]`, wantErr: "unknown field"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			_, err := parseEndpoints([]byte(tc.data))
+
+			if tc.wantErr == "" && err != nil {
+				t.Fatalf("parseEndpoints() unexpected error - %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("parseEndpoints() error - got=%v, want containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewEndpointOverridesOnlyItsCopy(t *testing.T) {
+
+	app := &application{
+		errorLog: log.New(io.Discard, "", log.Ldate),
+		infoLog:  log.New(io.Discard, "", log.Ldate),
+		cfg:      &envConfig{Label: "owner", Annotation: "example.com/validate", NamespaceMode: namespaceModeOptIn},
+	}
+
+	ep, err := app.newEndpoint(endpointConfig{
+		Path:              "/validate/workloads",
+		Label:             "team",
+		NamespaceMode:     namespaceModeOptOut,
+		NamespaceSelector: "env=dev",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ep.app.cfg.Label != "team" || ep.app.cfg.NamespaceMode != namespaceModeOptOut || ep.app.selector == nil {
+		t.Errorf("endpoint settings not applied - %+v", ep.app.cfg)
+	}
+
+	if app.cfg.Label != "owner" || app.cfg.NamespaceMode != namespaceModeOptIn || app.selector != nil {
+		t.Errorf("endpoint settings changed the application - %+v", app.cfg)
+	}
+
+	if len(ep.validators) != len(allValidators) {
+		t.Errorf("endpoint without validators - got=%v, want=%v", ep.validators, allValidators)
+	}
+
+	if _, err := app.newEndpoint(endpointConfig{Path: "/a", NamespaceMode: "sometimes"}); err == nil {
+		t.Errorf("newEndpoint() accepted an invalid namespace mode")
+	}
+
+	if _, err := app.newEndpoint(endpointConfig{Path: "/a", FailurePolicy: "Sometimes"}); err == nil {
+		t.Errorf("newEndpoint() accepted an invalid failure policy")
+	}
+}
+
+func TestExemptReason(t *testing.T) {
+
+	e := exemptions{
+		namespaces: toSet([]string{"kube-system"}),
+		users:      toSet([]string{"system:serviceaccount:argocd:argocd"}),
+		groups:     toSet([]string{"platform-admins"}),
+	}
+
+	tt := []struct {
+		name      string
+		namespace string
+		user      authenticationv1.UserInfo
+		exempt    bool
+	}{
+		{name: "exempt namespace", namespace: "kube-system", exempt: true},
+		{name: "exempt user", namespace: "default", user: authenticationv1.UserInfo{Username: "system:serviceaccount:argocd:argocd"}, exempt: true},
+		{name: "exempt group", namespace: "default", user: authenticationv1.UserInfo{Username: "jane", Groups: []string{"dev", "platform-admins"}}, exempt: true},
+		{name: "not exempt", namespace: "default", user: authenticationv1.UserInfo{Username: "jane", Groups: []string{"dev"}}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := e.exemptReason(tc.namespace, tc.user) != ""; got != tc.exempt {
+				t.Errorf("exemptReason() exempt - got=%v, want=%v", got, tc.exempt)
+			}
+		})
+	}
+}
+
+// TestEndpointRoutes - every endpoint is served with its own validators and settings
+func TestEndpointRoutes(t *testing.T) {
+
+	client := fake.NewSimpleClientset()
+
+	// the namespace is not annotated, it is only validated by the opt-out endpoint
+	_, err := client.CoreV1().Namespaces().Create(context.Background(),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "webhook-demo"}}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		errorLog: log.New(io.Discard, "", log.Ldate),
+		infoLog:  log.New(io.Discard, "", log.Ldate),
+		cfg:      &envConfig{Label: "owner", Annotation: "example.com/validate", NamespaceMode: namespaceModeOptIn},
+		client:   client,
+	}
+
+	app.endpoints, err = app.LoadEndpoints("test-files/endpoints.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(app.setupRoutes())
+	defer srv.Close()
+
+	tt := []struct {
+		name       string
+		path       string
+		statusCode int
+		allowed    bool
+	}{
+		{name: "opt-out endpoint denies the Pod", path: "/validate/pods", statusCode: http.StatusOK, allowed: false},
+		{name: "opt-in endpoint skips the Pod", path: "/validate/opt-in", statusCode: http.StatusOK, allowed: true},
+		{name: "exempt namespace is allowed", path: "/validate/exempt", statusCode: http.StatusOK, allowed: true},
+		{name: "namespace endpoint does not handle Pods", path: "/validate/namespaces", statusCode: http.StatusBadRequest},
+		{name: "default endpoint is not served", path: "/validate", statusCode: http.StatusNotFound},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			f, err := os.Open("test-files/admission-request-missing-labels.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			res, err := http.Post(srv.URL+tc.path, "application/json", f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != tc.statusCode {
+				t.Fatalf("HTTP status code mismatch want=%v, got=%v", tc.statusCode, res.StatusCode)
+			}
+
+			if res.StatusCode != http.StatusOK {
+				return
+			}
+
+			var review admissionv1.AdmissionReview
+			if err := json.NewDecoder(res.Body).Decode(&review); err != nil {
+				t.Fatal(err)
+			}
+
+			if review.Response.Allowed != tc.allowed {
+				t.Errorf("allowed mismatch want=%v, got=%v - %v", tc.allowed, review.Response.Allowed, review.Response.Result.Message)
+			}
+		})
+	}
+}
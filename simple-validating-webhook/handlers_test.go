@@ -48,6 +48,7 @@ func TestValidateWebhookHandler(t *testing.T) {
 		annotationKey   string
 		annotationValue string
 		statusCode      int
+		apiVersion      string // apiVersion of the response, defaults to admission.k8s.io/v1
 	}{
 		{
 			name:            "Pod is missing label owner and namespace has correct annotations",
@@ -73,6 +74,40 @@ func TestValidateWebhookHandler(t *testing.T) {
 			annotationValue: "false",
 			statusCode:      http.StatusOK,
 		},
+		{
+			name:            "v1beta1 Pod is missing label owner and namespace has correct annotations",
+			allowed:         false,
+			sourceJsonFile:  "test-files/admission-request-missing-labels-v1beta1.json",
+			annotationKey:   "example.com/validate",
+			annotationValue: "true",
+			statusCode:      http.StatusOK,
+			apiVersion:      "admission.k8s.io/v1beta1",
+		},
+		{
+			name:            "v1beta1 Pod without requestKind is missing label owner",
+			allowed:         false,
+			sourceJsonFile:  "test-files/admission-request-missing-labels-v1beta1-without-request-kind.json",
+			annotationKey:   "example.com/validate",
+			annotationValue: "true",
+			statusCode:      http.StatusOK,
+			apiVersion:      "admission.k8s.io/v1beta1",
+		},
+		{
+			name:            "v1beta1 Pod has correct labels and namespace has correct annotations",
+			allowed:         true,
+			sourceJsonFile:  "test-files/admission-request-with-labels-v1beta1.json",
+			annotationKey:   "example.com/validate",
+			annotationValue: "true",
+			statusCode:      http.StatusOK,
+			apiVersion:      "admission.k8s.io/v1beta1",
+		},
+		{
+			name:            "Test with an unknown AdmissionReview apiVersion",
+			sourceJsonFile:  "test-files/admission-request-unknown-version.json",
+			annotationKey:   "example.com/validate",
+			annotationValue: "true",
+			statusCode:      http.StatusBadRequest,
+		},
 		{
 			name:            "Test with empty Admission request object",
 			allowed:         false, // this field is not checked as the response does not contain valid Response
@@ -120,7 +155,7 @@ func TestValidateWebhookHandler(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 
-			handler := http.HandlerFunc(app.validate)
+			handler := app.newWebhookServer(exemptions{}, allValidators...)
 
 			// send Admission review loaded from the json file
 			req, err := http.NewRequest("POST", "/validate", f)
@@ -156,6 +191,14 @@ func TestValidateWebhookHandler(t *testing.T) {
 
 			//t.Log(result)
 
+			wantAPIVersion := tc.apiVersion
+			if wantAPIVersion == "" {
+				wantAPIVersion = "admission.k8s.io/v1"
+			}
+			if result.APIVersion != wantAPIVersion {
+				t.Errorf("AdmissionReview apiVersion: want=%v got=%v", wantAPIVersion, result.APIVersion)
+			}
+
 			admissionReviewReqAllowed := result.Response.Allowed
 
 			if admissionReviewReqAllowed != tc.allowed {
@@ -166,3 +209,96 @@ func TestValidateWebhookHandler(t *testing.T) {
 	}
 
 }
+
+func TestValidateNamespaceWebhookHandler(t *testing.T) {
+
+	tt := []struct {
+		name           string
+		allowed        bool
+		sourceJsonFile string
+		adminUsers     []string
+	}{
+		{
+			name:           "new Namespace has the required labels and annotations",
+			allowed:        true,
+			sourceJsonFile: "test-files/admission-request-namespace-create-with-labels.json",
+		},
+		{
+			name:           "new Namespace is missing the required labels and annotations",
+			allowed:        false,
+			sourceJsonFile: "test-files/admission-request-namespace-create-missing-labels.json",
+		},
+		{
+			name:           "non-admin user disables the validation of the Namespace",
+			allowed:        false,
+			sourceJsonFile: "test-files/admission-request-namespace-update-disable-validation.json",
+		},
+		{
+			name:           "v1beta1 new Namespace is missing the required labels and annotations",
+			allowed:        false,
+			sourceJsonFile: "test-files/admission-request-namespace-create-missing-labels-v1beta1.json",
+		},
+		{
+			name:           "v1beta1 non-admin user disables the validation of the Namespace",
+			allowed:        false,
+			sourceJsonFile: "test-files/admission-request-namespace-update-disable-validation-v1beta1.json",
+		},
+		{
+			name:           "admin user disables the validation of the Namespace",
+			allowed:        true,
+			sourceJsonFile: "test-files/admission-request-namespace-update-disable-validation.json",
+			adminUsers:     []string{"jane"},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc // capture inner variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			app := &application{
+				errorLog: log.New(io.Discard, "", log.Ldate),
+				infoLog:  log.New(io.Discard, "", log.Ldate),
+				cfg: &envConfig{
+					Annotation:                   "example.com/validate",
+					Label:                        "owner",
+					NamespaceRequiredLabels:      []string{"owner", "cost-center"},
+					NamespaceRequiredAnnotations: []string{"example.com/validate"},
+					AdminUsers:                   tc.adminUsers,
+					AdminGroups:                  []string{"system:masters"},
+				},
+				client: fake.NewSimpleClientset(),
+			}
+
+			f, err := os.Open(tc.sourceJsonFile)
+			if err != nil {
+				t.Fatalf("Failed to load input json file %v", err.Error())
+			}
+			defer f.Close()
+
+			rr := httptest.NewRecorder()
+
+			req, err := http.NewRequest("POST", "/validate", f)
+			if err != nil {
+				t.Fatalf("Failed to create the request object %v", err.Error())
+			}
+
+			app.newWebhookServer(exemptions{}, allValidators...).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("HTTP status code mismatch want=%v, got=%v", http.StatusOK, rr.Code)
+			}
+
+			result := admissionv1.AdmissionReview{}
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatalf("Failed to decode the Json response to AdmissionReview object %v", err.Error())
+			}
+
+			t.Log(result.Response.Result.Message)
+
+			if result.Response.Allowed != tc.allowed {
+				t.Errorf("AdmissionReview.Response.Allowed field: want=%v got=%v", tc.allowed, result.Response.Allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	chi "github.com/go-chi/chi/v5"
+)
+
+var (
+	// lockProbeTimeout - a lock that can not be acquired in this time is considered deadlocked
+	lockProbeTimeout = 5 * time.Second
+	// lockProbeInterval - how often a lock that is held is tried again
+	lockProbeInterval = 10 * time.Millisecond
+)
+
+// tryLocker is a lock probed without blocking, sync.Mutex and sync.RWMutex
+type tryLocker interface {
+	TryLock() bool
+	Unlock()
+}
+
+// healthCheck is a named readiness or liveness check, a nil error means healthy
+type healthCheck struct {
+	name  string
+	check func(r *http.Request) error
+}
+
+// readyChecks - the checks of /readyz, the replica only receives admission requests while they pass
+func (app *application) readyChecks() []healthCheck {
+
+	checks := []healthCheck{
+		{name: "ping", check: func(*http.Request) error { return nil }},
+		{name: "tls-cert", check: func(*http.Request) error { return checkCertificate(app.certificate, time.Now()) }},
+		{name: "shutdown", check: func(*http.Request) error {
+			if app.draining != nil && app.draining.Load() {
+				return fmt.Errorf("the server is shutting down")
+			}
+			return nil
+		}},
+	}
+
+	if app.namespacesSynced != nil {
+		checks = append(checks, healthCheck{name: "namespace-cache", check: func(*http.Request) error {
+			if !app.namespacesSynced() {
+				return fmt.Errorf("the namespace cache has not synced yet")
+			}
+			return nil
+		}})
+	}
+
+	checks = append(checks, healthCheck{name: "policy", check: func(*http.Request) error {
+		if app.teams != nil && !app.teams.isLoaded() {
+			return fmt.Errorf("the team registry has not been loaded")
+		}
+		if app.rego != nil && !app.rego.loaded() {
+			return fmt.Errorf("the Rego policies have not been loaded")
+		}
+		return nil
+	}})
+
+	return checks
+}
+
+// liveChecks - the checks of /livez, the replica is restarted when they fail
+func (app *application) liveChecks() []healthCheck {
+
+	checks := []healthCheck{
+		{name: "ping", check: func(*http.Request) error { return nil }},
+		{name: "locks", check: func(*http.Request) error { return app.probeLocks() }},
+	}
+
+	if app.inflight != nil {
+		checks = append(checks, healthCheck{name: "admissions", check: func(*http.Request) error {
+			if age := app.inflight.oldest(time.Now()); age > app.cfg.LivenessStuckThreshold {
+				return fmt.Errorf("an admission request has been running for %v", age.Round(time.Second))
+			}
+			return nil
+		}})
+	}
+
+	if app.elector != nil {
+		checks = append(checks, healthCheck{name: "leader-election", check: func(*http.Request) error {
+			return app.elector.Check(app.cfg.LeaderElectionLeaseDuration)
+		}})
+	}
+
+	return checks
+}
+
+// checkCertificate - returns an error if the certificate is missing or not valid at the time
+func checkCertificate(cert *x509.Certificate, now time.Time) error {
+
+	switch {
+	case cert == nil:
+		return fmt.Errorf("no TLS certificate loaded")
+	case now.Before(cert.NotBefore):
+		return fmt.Errorf("the TLS certificate is not valid before %v", cert.NotBefore.UTC().Format(time.RFC3339))
+	case now.After(cert.NotAfter):
+		return fmt.Errorf("the TLS certificate expired at %v", cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// probeLocks - returns an error if one of the locks shared by the admission requests and the background
+// work can not be acquired, a lock that is never released blocks every admission request. The locks are
+// tried until the timeout instead of waited for, the probe never blocks on a stuck lock
+func (app *application) probeLocks() error {
+
+	locks := map[string]tryLocker{}
+	if app.teams != nil {
+		locks["team registry"] = &app.teams.mu
+	}
+	if app.rego != nil {
+		locks["Rego policies"] = &app.rego.mu
+	}
+	if app.scanner != nil {
+		locks["background scan"] = &app.scanner.mu
+	}
+	if app.reports != nil {
+		locks["policy reports"] = &app.reports.mu
+	}
+
+	for name, lock := range locks {
+		deadline := time.Now().Add(lockProbeTimeout)
+		for !lock.TryLock() {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("the lock of the %v was not acquired in %v", name, lockProbeTimeout)
+			}
+			time.Sleep(lockProbeInterval)
+		}
+		lock.Unlock()
+	}
+
+	return nil
+}
+
+// healthHandler - runs the checks in the format of the kube-apiserver, e.g. GET /readyz?verbose lists
+// every check, /readyz/tls-cert runs a single check and ?exclude=name skips a check
+func (app *application) healthHandler(kind string, checks func() []healthCheck) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		selected := checks()
+
+		if name := chi.URLParam(r, "check"); name != "" {
+			var single []healthCheck
+			for _, c := range selected {
+				if c.name == name {
+					single = append(single, c)
+				}
+			}
+			if len(single) == 0 {
+				http.Error(w, fmt.Sprintf("no %v check named %q", kind, name), http.StatusNotFound)
+				return
+			}
+			selected = single
+		}
+
+		excluded := map[string]bool{}
+		for _, name := range r.URL.Query()["exclude"] {
+			excluded[name] = true
+		}
+
+		_, verbose := r.URL.Query()["verbose"]
+
+		var out bytes.Buffer
+		failed := false
+
+		for _, c := range selected {
+			if excluded[c.name] {
+				fmt.Fprintf(&out, "[+]%v excluded: ok\n", c.name)
+				continue
+			}
+			if err := c.check(r); err != nil {
+				failed = true
+				app.errorLog.Printf("%v check %v failed - %v", kind, c.name, err)
+				if verbose {
+					fmt.Fprintf(&out, "[-]%v failed: %v\n", c.name, err)
+				} else {
+					fmt.Fprintf(&out, "[-]%v failed: reason withheld\n", c.name)
+				}
+				continue
+			}
+			fmt.Fprintf(&out, "[+]%v ok\n", c.name)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%v%v check failed\n", out.String(), kind)
+			return
+		}
+
+		if !verbose {
+			fmt.Fprint(w, "ok")
+			return
+		}
+
+		fmt.Fprintf(w, "%v%v check passed\n", out.String(), kind)
+	}
+}
+
+// inflightTracker records the start time of the admission requests that are being served
+type inflightTracker struct {
+	mu      sync.Mutex
+	next    uint64
+	started map[uint64]time.Time
+}
+
+func newInflightTracker() *inflightTracker {
+	return &inflightTracker{started: map[uint64]time.Time{}}
+}
+
+// Track - records a request from the time its AdmissionReview was decoded until it was answered
+func (t *inflightTracker) Track() func() {
+
+	t.mu.Lock()
+	id := t.next
+	t.next++
+	t.started[id] = time.Now()
+	t.mu.Unlock()
+
+	return func() {
+		t.mu.Lock()
+		delete(t.started, id)
+		t.mu.Unlock()
+	}
+}
+
+// oldest - returns for how long the oldest request in flight has been served, 0 when there is none
+func (t *inflightTracker) oldest(now time.Time) time.Duration {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var oldest time.Duration
+	for _, started := range t.started {
+		if age := now.Sub(started); age > oldest {
+			oldest = age
+		}
+	}
+
+	return oldest
+}
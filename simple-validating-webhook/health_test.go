@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestCheckCertificate(t *testing.T) {
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	valid := &x509.Certificate{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}
+
+	tt := []struct {
+		name    string
+		cert    *x509.Certificate
+		wantErr string
+	}{
+		{name: "valid certificate", cert: valid},
+		{name: "no certificate", wantErr: "no TLS certificate"},
+		{name: "expired certificate", cert: &x509.Certificate{NotBefore: now.Add(-2 * time.Hour), NotAfter: now.Add(-time.Hour)}, wantErr: "expired"},
+		{name: "certificate not valid yet", cert: &x509.Certificate{NotBefore: now.Add(time.Hour), NotAfter: now.Add(2 * time.Hour)}, wantErr: "not valid before"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkCertificate(tc.cert, now)
+			if tc.wantErr == "" && err != nil {
+				t.Fatalf("checkCertificate() unexpected error - %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("checkCertificate() error - got=%v, want containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := &application{
+		errorLog:    log.New(io.Discard, "", log.Ldate),
+		infoLog:     log.New(io.Discard, "", log.Ldate),
+		cfg:         &envConfig{LivenessStuckThreshold: time.Minute},
+		client:      fake.NewSimpleClientset(),
+		certificate: &x509.Certificate{NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)},
+		teams:       NewTeamRegistry(), // not loaded until its source is read
+	}
+	app.startNamespaceCache(ctx)
+	if !cache.WaitForCacheSync(ctx.Done(), app.namespacesSynced) {
+		t.Fatal("namespace cache did not sync")
+	}
+
+	srv := httptest.NewServer(app.setupRoutes())
+	defer srv.Close()
+
+	// an admission request that has been running for longer than the threshold
+	app.inflight.started[42] = time.Now().Add(-2 * time.Minute)
+
+	tt := []struct {
+		name       string
+		path       string
+		statusCode int
+		wantBody   []string
+	}{
+		{
+			name:       "readyz fails while the team registry is not loaded",
+			path:       "/readyz",
+			statusCode: http.StatusInternalServerError,
+			wantBody:   []string{"[+]tls-cert ok", "[+]namespace-cache ok", "[-]policy failed: reason withheld", "readyz check failed"},
+		},
+		{
+			name:       "verbose readyz shows the reason",
+			path:       "/readyz?verbose",
+			statusCode: http.StatusInternalServerError,
+			wantBody:   []string{"[-]policy failed: the team registry has not been loaded"},
+		},
+		{
+			name:       "readyz with the failing check excluded",
+			path:       "/readyz?exclude=policy",
+			statusCode: http.StatusOK,
+			wantBody:   []string{"ok"},
+		},
+		{
+			name:       "verbose readyz of a single check",
+			path:       "/readyz/tls-cert?verbose",
+			statusCode: http.StatusOK,
+			wantBody:   []string{"[+]tls-cert ok", "readyz check passed"},
+		},
+		{
+			name:       "unknown check",
+			path:       "/readyz/etcd",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			name:       "livez fails with a stuck admission request",
+			path:       "/livez?verbose",
+			statusCode: http.StatusInternalServerError,
+			wantBody:   []string{"[+]ping ok", "[+]locks ok", "[-]admissions failed: an admission request has been running for 2m0s"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			res, err := http.Get(srv.URL + tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != tc.statusCode {
+				t.Fatalf("HTTP status code mismatch want=%v, got=%v", tc.statusCode, res.StatusCode)
+			}
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range tc.wantBody {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("body does not contain %q\n%s", want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestProbeLocksWithAStuckLock(t *testing.T) {
+
+	timeout := lockProbeTimeout
+	lockProbeTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { lockProbeTimeout = timeout })
+
+	app := &application{teams: NewTeamRegistry()}
+
+	// the probe of a lock that is never released fails without leaving a goroutine blocked on it
+	app.teams.mu.Lock()
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 3; i++ {
+		if err := app.probeLocks(); err == nil {
+			t.Fatalf("probeLocks() succeeded with the team registry locked")
+		}
+	}
+	if got := runtime.NumGoroutine(); got > goroutines {
+		t.Errorf("goroutines after the probes - got=%v, want at most %v", got, goroutines)
+	}
+
+	app.teams.mu.Unlock()
+	if err := app.probeLocks(); err != nil {
+		t.Errorf("probeLocks() after the lock was released - %v", err)
+	}
+}
+
+func TestGetNamespaceUsesTheCache(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}})
+	app := &application{
+		errorLog: log.New(io.Discard, "", log.Ldate),
+		infoLog:  log.New(io.Discard, "", log.Ldate),
+		cfg:      &envConfig{},
+		client:   client,
+	}
+	app.startNamespaceCache(ctx)
+	if !cache.WaitForCacheSync(ctx.Done(), app.namespacesSynced) {
+		t.Fatal("namespace cache did not sync")
+	}
+	client.ClearActions()
+
+	if _, err := app.getNamespace(ctx, "team-a"); err != nil {
+		t.Fatal(err)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("cached namespace fetched from the API server - %v", actions)
+	}
+
+	// a namespace missing from the cache is fetched from the API server
+	if _, err := app.getNamespace(ctx, "team-b"); err == nil {
+		t.Errorf("getNamespace() found a namespace that does not exist")
+	}
+	if actions := client.Actions(); len(actions) != 1 || actions[0].GetVerb() != "get" {
+		t.Errorf("namespace missing from the cache - actions=%v, want a single get", actions)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// singleton is background work that must run on a single replica, e.g. the background scan, it runs
+// until the context is cancelled
+type singleton struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+// runSingletons - starts the singletons, with LEADER_ELECTION only while this replica holds the
+// Lease, every replica keeps serving the admission requests either way. wg is done once the singletons
+// stopped after the context is cancelled, and the leader released the Lease
+func (app *application) runSingletons(ctx context.Context, wg *sync.WaitGroup, singletons []singleton) error {
+
+	if len(singletons) == 0 {
+		return nil
+	}
+
+	if !app.cfg.LeaderElection {
+		for _, s := range singletons {
+			wg.Add(1)
+			go func(s singleton) {
+				defer wg.Done()
+				s.run(ctx)
+			}(s)
+		}
+		return nil
+	}
+
+	// held by a term of leadership while its singletons run, see newLeaderElector
+	var term sync.Mutex
+
+	elector, err := app.newLeaderElector(singletons, &term)
+	if err != nil {
+		return err
+	}
+	app.elector = elector
+
+	// Run returns when the leadership is lost without waiting for the singletons of the term, the replica
+	// campaigns again once they stopped
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			elector.Run(ctx)
+			term.Lock()
+			term.Unlock()
+		}
+	}()
+
+	return nil
+}
+
+// leaderIdentity - returns the identity of the replica in the Lease, the pod name
+func (app *application) leaderIdentity() (string, error) {
+
+	if app.cfg.PodName != "" {
+		return app.cfg.PodName, nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("error getting the leader election identity, set POD_NAME - %v", err)
+	}
+
+	return hostname, nil
+}
+
+// newLeaderElector - returns an elector of the Lease LEADER_ELECTION_LEASE that runs the singletons while
+// leading, they are stopped when the Lease can not be renewed. A term holds the term lock until its
+// singletons stopped, the singletons of two terms never run at the same time
+func (app *application) newLeaderElector(singletons []singleton, term *sync.Mutex) (*leaderelection.LeaderElector, error) {
+
+	identity, err := app.leaderIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(singletons))
+	for _, s := range singletons {
+		names = append(names, s.name)
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      app.cfg.LeaderElectionLease,
+			Namespace: app.cfg.LeaderElectionNamespace,
+		},
+		Client:     app.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Name:            app.cfg.LeaderElectionLease,
+		Lock:            lock,
+		LeaseDuration:   app.cfg.LeaderElectionLeaseDuration,
+		RenewDeadline:   app.cfg.LeaderElectionRenewDeadline,
+		RetryPeriod:     app.cfg.LeaderElectionRetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			// client-go runs the callback in its own goroutine, a term that starts after its leadership
+			// was already lost does nothing
+			OnStartedLeading: func(ctx context.Context) {
+				term.Lock()
+				defer term.Unlock()
+
+				if ctx.Err() != nil {
+					return
+				}
+
+				leader.Set(1)
+				app.infoLog.Printf("Became the leader as %v, starting %v", identity, strings.Join(names, ", "))
+
+				var wg sync.WaitGroup
+				for _, s := range singletons {
+					wg.Add(1)
+					go func(s singleton) {
+						defer wg.Done()
+						s.run(ctx)
+					}(s)
+				}
+				wg.Wait()
+
+				leader.Set(0)
+				app.infoLog.Printf("Stopped leading as %v, stopped %v", identity, strings.Join(names, ", "))
+			},
+			OnStoppedLeading: func() {
+				app.infoLog.Printf("Lost the Lease as %v, stopping %v", identity, strings.Join(names, ", "))
+			},
+			OnNewLeader: func(current string) {
+				if current != identity {
+					app.infoLog.Printf("The leader is %v, %v runs there", current, strings.Join(names, ", "))
+				}
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid leader election settings - %v", err)
+	}
+
+	return elector, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// running records the replicas that run their singleton
+type running struct {
+	mu       sync.Mutex
+	replicas map[string]bool
+}
+
+func (r *running) singleton(name string) singleton {
+	return singleton{name: "test", run: func(ctx context.Context) {
+		r.mu.Lock()
+		r.replicas[name] = true
+		r.mu.Unlock()
+
+		<-ctx.Done()
+
+		r.mu.Lock()
+		delete(r.replicas, name)
+		r.mu.Unlock()
+	}}
+}
+
+func (r *running) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var names []string
+	for name := range r.replicas {
+		names = append(names, name)
+	}
+	return names
+}
+
+// waitForSingleLeader - waits until exactly one replica runs its singleton and returns it
+func (r *running) waitForSingleLeader(t *testing.T) string {
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if names := r.get(); len(names) == 1 {
+			return names[0]
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("replicas running the singletons - got=%v, want exactly one", r.get())
+	return ""
+}
+
+func newLeaderTestApp(client kubernetes.Interface, name string, election bool) *application {
+	return &application{
+		errorLog: log.New(io.Discard, "", log.Ldate),
+		infoLog:  log.New(io.Discard, "", log.Ldate),
+		client:   client,
+		cfg: &envConfig{
+			LeaderElection:              election,
+			LeaderElectionNamespace:     "webhook-demo",
+			LeaderElectionLease:         "simple-validating-webhook",
+			LeaderElectionLeaseDuration: time.Second,
+			LeaderElectionRenewDeadline: 500 * time.Millisecond,
+			LeaderElectionRetryPeriod:   100 * time.Millisecond,
+			PodName:                     name,
+		},
+	}
+}
+
+func TestRunSingletonsWithLeaderElection(t *testing.T) {
+
+	client := fake.NewSimpleClientset()
+	r := &running{replicas: map[string]bool{}}
+
+	cancels := map[string]context.CancelFunc{}
+	stopped := map[string]*sync.WaitGroup{}
+	for _, name := range []string{"replica-a", "replica-b"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		cancels[name] = cancel
+		stopped[name] = &sync.WaitGroup{}
+
+		if err := newLeaderTestApp(client, name, true).runSingletons(ctx, stopped[name], []singleton{r.singleton(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := r.waitForSingleLeader(t)
+
+	// the Lease is released by the time the stopped leader is done, the other replica then takes over
+	cancels[first]()
+	stopped[first].Wait()
+
+	lease, err := client.CoordinationV1().Leases("webhook-demo").Get(context.Background(), "simple-validating-webhook", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder := lease.Spec.HolderIdentity; holder != nil && *holder == first {
+		t.Errorf("Lease still held by %v after it stopped", first)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if names := r.get(); len(names) == 1 && names[0] != first {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("replicas running the singletons after %v stopped - got=%v", first, r.get())
+}
+
+func TestSingletonsOfTwoTermsDoNotOverlap(t *testing.T) {
+
+	client := fake.NewSimpleClientset()
+
+	// the Lease can not be renewed while failing is set
+	var failing atomic.Bool
+	client.PrependReactor("update", "leases", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failing.Load() {
+			return true, nil, errors.New("the API server is not available")
+		}
+		return false, nil, nil
+	})
+
+	// the singleton takes a while to stop once its term ended
+	var running, overlaps, terms atomic.Int32
+	stopping := make(chan struct{}, 2)
+	s := singleton{name: "slow", run: func(ctx context.Context) {
+		if running.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		terms.Add(1)
+		<-ctx.Done()
+		stopping <- struct{}{}
+		time.Sleep(300 * time.Millisecond)
+		running.Add(-1)
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var stopped sync.WaitGroup
+	if err := newLeaderTestApp(client, "replica-a", true).runSingletons(ctx, &stopped, []singleton{s}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor := func(what string, done func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %v", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// the leadership is lost and won again while the singleton of the first term is still stopping
+	waitFor("the first term", func() bool { return terms.Load() == 1 })
+	failing.Store(true)
+	<-stopping
+	failing.Store(false)
+	waitFor("the second term", func() bool { return terms.Load() == 2 })
+
+	// the singleton of the last term has stopped by the time the replica is done
+	cancel()
+	stopped.Wait()
+
+	if got := overlaps.Load(); got != 0 {
+		t.Errorf("the singleton ran twice at the same time %v times", got)
+	}
+	if got := running.Load(); got != 0 {
+		t.Errorf("singletons still running after the replica stopped - got=%v", got)
+	}
+}
+
+func TestRunSingletonsWithoutLeaderElection(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := &running{replicas: map[string]bool{}}
+	var stopped sync.WaitGroup
+	if err := newLeaderTestApp(nil, "replica-a", false).runSingletons(ctx, &stopped, []singleton{r.singleton("replica-a")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := r.waitForSingleLeader(t); got != "replica-a" {
+		t.Errorf("replica running the singletons - got=%v, want=replica-a", got)
+	}
+
+	cancel()
+	stopped.Wait()
+}
+
+func TestLeaderElectionSettingsAreValidated(t *testing.T) {
+
+	app := newLeaderTestApp(fake.NewSimpleClientset(), "replica-a", true)
+	app.cfg.LeaderElectionRenewDeadline = 2 * app.cfg.LeaderElectionLeaseDuration
+
+	if err := app.runSingletons(context.Background(), &sync.WaitGroup{}, []singleton{{name: "test", run: func(context.Context) {}}}); err == nil {
+		t.Errorf("runSingletons() accepted a renew deadline longer than the lease duration")
+	}
+}
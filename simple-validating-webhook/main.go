@@ -3,14 +3,22 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 	
 	"github.com/caarlos0/env/v6"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/dynamic"
+
+	"simple-validating-webhook/webhook"
 )
 
 func main() {
@@ -25,12 +33,56 @@ func main() {
 		errorLog.Fatalln(err)
 	}
 	
+	messages, err := LoadMessageTemplates(cfg.MessageTemplatesPath)
+
+	if err != nil {
+		errorLog.Fatalln(err)
+	}
+
+	if err := ValidateNamespaceMode(cfg.NamespaceMode); err != nil {
+		errorLog.Fatalln(err)
+	}
+
+	if err := ValidateFailurePolicy(cfg.FailurePolicy); err != nil {
+		errorLog.Fatalln(err)
+	}
+
+	tlsConfig, err := NewTLSConfig(&cfg)
+
+	if err != nil {
+		errorLog.Fatalln(err)
+	}
+
+	selector, err := ParseNamespaceSelector(cfg.NamespaceSelector)
+
+	if err != nil {
+		errorLog.Fatalln(err)
+	}
+
+	celRules, err := LoadCELRules(cfg.CELRulesPath)
+
+	if err != nil {
+		errorLog.Fatalln(err)
+	}
+
 	config, err := GetKubeConfig()
 	
 	if err != nil {
 		errorLog.Fatal(err)
 	}
 	
+	// the spans are flushed after the shutdown of the servers
+	shutdownTracing, err := SetupTracing(context.Background(), &cfg)
+
+	if err != nil {
+		errorLog.Fatalln(err)
+	}
+
+	if cfg.TracingExporter != "none" && cfg.TracingExporter != "" {
+		traceKubeClient(config)
+		infoLog.Printf("Exporting the traces to %v with a sample ratio of %v", cfg.TracingExporter, cfg.TracingSampleRatio)
+	}
+
 	client, err := NewKubeClient(config)
 	
 	if err != nil {
@@ -42,34 +94,194 @@ func main() {
 		infoLog:  infoLog,
 		cfg:      &cfg,
 		client:   client,
+		messages: messages,
+		selector: selector,
+		celRules: celRules,
+		draining: &atomic.Bool{},
 	}
 	
+	// background workers, e.g. the team registry watcher, are stopped with this context on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the endpoints copy the application, the cache is shared by all of them
+	if cfg.NamespaceCache {
+		app.startNamespaceCache(ctx)
+	}
+
+	switch {
+	case cfg.TeamRegistryConfigMap != "":
+		app.teams = NewTeamRegistry()
+		if err := app.teams.watchConfigMap(ctx, client, cfg.TeamRegistryConfigMap, cfg.TeamRegistryKey, infoLog, errorLog); err != nil {
+			errorLog.Fatalln(err)
+		}
+	case cfg.TeamRegistryPath != "":
+		app.teams = NewTeamRegistry()
+		go app.teams.watchFile(ctx, cfg.TeamRegistryPath, cfg.TeamRegistryPollInterval, infoLog, errorLog)
+	}
+
+	if cfg.RegoPolicyDir != "" {
+		if app.rego, err = NewRegoPolicy(ctx, cfg.RegoPolicyDir, cfg.RegoDecisionPath); err != nil {
+			errorLog.Fatalln(err)
+		}
+		go app.rego.watch(ctx, cfg.RegoPollInterval, infoLog, errorLog)
+	}
+
+	if cfg.WASMPluginDir != "" {
+		app.wasm, err = LoadWASMPlugins(ctx, cfg.WASMPluginDir, wasmLimits{
+			MemoryPages:    cfg.WASMMemoryLimitPages,
+			Timeout:        cfg.WASMTimeout,
+			MaxConcurrency: cfg.WASMMaxConcurrency,
+			FailurePolicy:  cfg.WASMFailurePolicy,
+		})
+		if err != nil {
+			errorLog.Fatalln(err)
+		}
+		defer app.wasm.Close(context.Background())
+		infoLog.Printf("Loaded %d WASM plugins from %v", len(app.wasm.plugins), cfg.WASMPluginDir)
+	}
+
+	if cfg.DecisionsDBPath != "" {
+		if app.decisions, err = OpenDecisionStore(cfg.DecisionsDBPath, cfg.DecisionsRetention); err != nil {
+			errorLog.Fatalln(err)
+		}
+		defer app.decisions.Close()
+		go app.decisions.watch(ctx, cfg.DecisionsPruneInterval, infoLog, errorLog)
+		infoLog.Printf("Recording the admission decisions in %v", cfg.DecisionsDBPath)
+	}
+
+	// background work that runs on a single replica, see runSingletons, main waits for it and for the
+	// PolicyReports writer of every replica before exiting
+	var (
+		singletons []singleton
+		background sync.WaitGroup
+	)
+
+	if cfg.PolicyReports {
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			errorLog.Fatalln(err)
+		}
+		app.reports = NewPolicyReporter(dynamicClient, infoLog, errorLog)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			app.reports.run(ctx, cfg.PolicyReportInterval)
+		}()
+	}
+
+	// the endpoints share the capacity of the process
+	if cfg.MaxInFlightAdmissions > 0 {
+		app.limiter = webhook.NewLimiter(cfg.MaxInFlightAdmissions, cfg.MaxQueuedAdmissions, cfg.AdmissionQueueTimeout)
+		registerLimiterMetrics(prometheus.DefaultRegisterer, app.limiter)
+		infoLog.Printf("Validating up to %v admission requests at a time with %v queued for up to %v",
+			cfg.MaxInFlightAdmissions, cfg.MaxQueuedAdmissions, cfg.AdmissionQueueTimeout)
+	}
+
+	// the endpoints share the team registry, the Rego policies, the WASM plugins, the decision history and the
+	// PolicyReports loaded above
+	if app.endpoints, err = app.LoadEndpoints(cfg.EndpointsPath); err != nil {
+		errorLog.Fatalln(err)
+	}
+
+	// the scanner checks the existing workloads with the validators of the endpoints
+	if cfg.ScanInterval > 0 {
+		app.scanner = app.newScanner(cfg.ScanInterval)
+		singletons = append(singletons, singleton{"background scan", app.scanner.run})
+	}
+
+	// with several replicas only the leader runs the background work
+	if err := app.runSingletons(ctx, &background, singletons); err != nil {
+		errorLog.Fatalln(err)
+	}
+
 	tlsPair, err := tls.LoadX509KeyPair(cfg.CertPath, cfg.KeyPath)
 	
 	if err != nil {
 		errorLog.Fatalln("Error loading TLS certs", err)
 	}
 	
+	// the expiry of the certificate is checked by /readyz
+	if app.certificate, err = x509.ParseCertificate(tlsPair.Certificate[0]); err != nil {
+		errorLog.Fatalln("Error parsing the TLS certificate", err)
+	}
+
+	tlsConfig.Certificates = []tls.Certificate{tlsPair}
+	infoLog.Printf("TLS %v", describeTLSConfig(cfg.TLSProfile, tlsConfig))
+
+	// the client certificates are verified by the listener, the callers without one can still use a bearer
+	// token, requireClient rejects the callers without either
+	if cfg.ClientCAPath != "" {
+		if tlsConfig.ClientCAs, err = LoadClientCAs(cfg.ClientCAPath); err != nil {
+			errorLog.Fatalln(err)
+		}
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		infoLog.Printf("Verifying the client certificates against %v", cfg.ClientCAPath)
+	}
+
+	if app.auth = NewClientAuthenticator(&cfg, client); app.auth != nil && cfg.ClientAuthTokenReview {
+		infoLog.Println("Validating the bearer tokens of the callers with TokenReviews")
+	}
+
 	server := &http.Server{
-		Addr:      fmt.Sprintf(":%v", cfg.Port), // Listen on all the interfaces
-		TLSConfig: &tls.Config{Certificates: []tls.Certificate{tlsPair}},
+		Addr:              fmt.Sprintf(":%v", cfg.Port), // Listen on all the interfaces
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second, // a slow client can not hold a connection with a trickled body
+		IdleTimeout:       120 * time.Second,
 	}
 	
 	server.Handler = app.setupRoutes()
 	
 	go func() {
 		infoLog.Printf("Starting the web server on port %v", cfg.Port)
-		errorLog.Println(server.ListenAndServeTLS("", ""))
+		if err := server.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+			errorLog.Println(err)
+		}
 	}()
 	
+	servers := []*http.Server{server}
+
+	// the admin server has its own listener and router, it keeps answering the probes and the
+	// scrapes whatever happens to the TLS listener
+	if cfg.AdminPort != 0 {
+		admin := &http.Server{
+			Addr:              fmt.Sprintf(":%v", cfg.AdminPort),
+			Handler:           app.setupAdminRoutes(),
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+		}
+		servers = append(servers, admin)
+
+		go func() {
+			infoLog.Printf("Starting the admin server on port %v", cfg.AdminPort)
+			if err := admin.ListenAndServe(); err != http.ErrServerClosed {
+				errorLog.Println(err)
+			}
+		}()
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
 	
-	app.infoLog.Println("Got shutdown signal, shutting down the web server")
+	app.infoLog.Println("Got shutdown signal, draining the web server")
 	
-	if err := server.Shutdown(context.Background()); err != nil {
-		errorLog.Fatal("failed to shutdown the web server gracefully", err)
+	if err := app.shutdown(servers...); err != nil {
+		errorLog.Println("failed to shutdown the web server gracefully", err)
 	}
 	
+	// the background work is stopped once the requests in flight are done, the leader releases the Lease
+	// and the pending PolicyReport results are written before exiting
+	cancel()
+	background.Wait()
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+
+	if err := shutdownTracing(flushCtx); err != nil {
+		errorLog.Println("failed to flush the traces", err)
+	}
+
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"text/template"
+
+	"sigs.k8s.io/yaml"
+)
+
+// names of the rules evaluated by the webhook, these are also the keys used
+// to override the denial message of a rule in the message templates file
+const (
+	ruleMissingLabel  = "missing-label"
+	ruleEmptyLabel    = "empty-label"
+	ruleLabelMismatch = "label-mismatch"
+	ruleUnknownTeam   = "unknown-team"
+
+	ruleMissingAnnotation   = "missing-annotation"
+	ruleEnforcementDisabled = "enforcement-disabled"
+
+	// ruleInvalidRequiredLabels is reported when the required labels annotation of the namespace can
+	// not be parsed, its message is not templated as it is meant for the namespace owner
+	ruleInvalidRequiredLabels = "invalid-required-labels"
+)
+
+// defaultMessageTemplates - denial messages used when a rule has no template in the message templates file
+var defaultMessageTemplates = map[string]string{
+	ruleMissingLabel: `Denied because the {{.Kind}} is missing label {{.Label}}` + remediationSuffix,
+	ruleEmptyLabel:   `Denied because the label {{.Label}} on the {{.Kind}} is empty` + remediationSuffix,
+	ruleLabelMismatch: `Denied because the label {{.Label}} on the {{.Kind}} has value {{.Value}} that does not match {{.Pattern}}` +
+		remediationSuffix,
+	ruleUnknownTeam: `Denied because the label {{.Label}} on the {{.Kind}} has value {{.Value}} that is not a registered team` +
+		`{{with .Suggestion}}, did you mean {{.}}?{{end}}` + remediationSuffix,
+	ruleMissingAnnotation: `Denied because the {{.Kind}} is missing annotation {{.Annotation}}` + remediationSuffix,
+	ruleEnforcementDisabled: `Denied because only admins can disable the validation of the {{.Kind}} {{.Name}}` +
+		remediationSuffix,
+}
+
+// remediationSuffix - appended to the default templates, renders only the fields that are set
+const remediationSuffix = `{{with .DocsURL}}, see {{.}}{{end}}{{with .Contact}}, contact {{.}} for help{{end}}`
+
+// messageData holds the fields that are available to a denial message template
+type messageData struct {
+	Rule       string // name of the rule that failed
+	Kind       string // kind of the object, e.g. Pod
+	Name       string // name of the object
+	Namespace  string // namespace of the object
+	Label      string // label key checked by the rule
+	Value      string // observed value of the label, empty if the label is missing
+	Pattern    string // regex the value has to match, only set for the label-mismatch rule
+	Annotation string // annotation key checked by the rule
+	Suggestion string // closest registered team, only set for the unknown-team rule
+	DocsURL    string // remediation docs URL from DOCS_URL
+	Contact    string // remediation contact from the namespace annotation, the team registry or DEFAULT_CONTACT
+}
+
+// messageTemplates holds the parsed denial message template of each rule
+type messageTemplates map[string]*template.Template
+
+// defaultMessages - parsed defaultMessageTemplates, used when no templates file is configured
+var defaultMessages = mustParseMessageTemplates(defaultMessageTemplates)
+
+// parseMessageTemplates - parses the templates and returns them merged on top of the defaults
+func parseMessageTemplates(raw map[string]string) (messageTemplates, error) {
+
+	merged := make(map[string]string, len(defaultMessageTemplates)+len(raw))
+	for rule, text := range defaultMessageTemplates {
+		merged[rule] = text
+	}
+
+	for rule, text := range raw {
+		if _, ok := defaultMessageTemplates[rule]; !ok {
+			return nil, fmt.Errorf("unknown rule %q in message templates", rule)
+		}
+		merged[rule] = text
+	}
+
+	templates := make(messageTemplates, len(merged))
+	for rule, text := range merged {
+		tmpl, err := template.New(rule).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing message template for rule %q - %v", rule, err)
+		}
+		templates[rule] = tmpl
+	}
+
+	return templates, nil
+}
+
+// mustParseMessageTemplates - same as parseMessageTemplates but panics on error
+func mustParseMessageTemplates(raw map[string]string) messageTemplates {
+	templates, err := parseMessageTemplates(raw)
+	if err != nil {
+		panic(err)
+	}
+	return templates
+}
+
+// LoadMessageTemplates - reads per-rule denial message templates from a YAML or JSON file
+// that maps a rule name to a Go template, rules that are not in the file keep the default message
+func LoadMessageTemplates(path string) (messageTemplates, error) {
+
+	if path == "" {
+		return defaultMessages, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading message templates file %v - %v", path, err)
+	}
+
+	raw := map[string]string{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing message templates file %v - %v", path, err)
+	}
+
+	return parseMessageTemplates(raw)
+}
+
+// render - executes the template of the rule with the given data
+func (m messageTemplates) render(rule string, data messageData) (string, error) {
+
+	tmpl, ok := m[rule]
+	if !ok {
+		return "", fmt.Errorf("no message template for rule %q", rule)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("error rendering message template for rule %q - %v", rule, err)
+	}
+
+	return buf.String(), nil
+}
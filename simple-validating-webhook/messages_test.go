@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLoadMessageTemplates(t *testing.T) {
+
+	tt := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "override a single rule",
+			content: `missing-label: "{{.Kind}} {{.Name}} needs label {{.Label}}"`,
+			wantErr: false,
+		},
+		{
+			name:    "unknown rule",
+			content: `no-such-rule: "whatever"`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid template",
+			content: `missing-label: "{{.Kind"`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+
+			path := filepath.Join(t.TempDir(), "templates.yaml")
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := LoadMessageTemplates(path)
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("LoadMessageTemplates() error = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			// rules missing from the file must keep their default template
+			if _, ok := got[ruleEmptyLabel]; !ok {
+				t.Errorf("LoadMessageTemplates() did not keep the default template for %v", ruleEmptyLabel)
+			}
+		})
+	}
+}
+
+func TestDenialMessage(t *testing.T) {
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "webhook-demo",
+			Annotations: map[string]string{
+				"example.com/contact": "#team-payments",
+			},
+		},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "busybox1",
+			Namespace: "webhook-demo",
+		},
+	}
+
+	custom := mustParseMessageTemplates(map[string]string{
+		ruleMissingLabel: "{{.Namespace}}/{{.Name}} is missing {{.Label}}, ask {{.Contact}} or read {{.DocsURL}}",
+	})
+
+	tt := []struct {
+		name     string
+		messages messageTemplates
+		cfg      *envConfig
+		ns       *corev1.Namespace
+		want     string
+	}{
+		{
+			name:     "default template without docs or contact",
+			messages: nil,
+			cfg:      &envConfig{Label: "owner", ContactAnnotation: "example.com/contact"},
+			ns:       &corev1.Namespace{},
+			want:     "Denied because the Pod is missing label owner",
+		},
+		{
+			name:     "default template with docs url and namespace contact",
+			messages: nil,
+			cfg:      &envConfig{Label: "owner", ContactAnnotation: "example.com/contact", DocsURL: "https://docs.example.com/labels"},
+			ns:       ns,
+			want:     "Denied because the Pod is missing label owner, see https://docs.example.com/labels, contact #team-payments for help",
+		},
+		{
+			name:     "custom template falls back to the default contact",
+			messages: custom,
+			cfg:      &envConfig{Label: "owner", ContactAnnotation: "example.com/contact", DocsURL: "https://docs", DefaultContact: "#platform"},
+			ns:       &corev1.Namespace{},
+			want:     "webhook-demo/busybox1 is missing owner, ask #platform or read https://docs",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+
+			app := &application{
+				errorLog: log.New(io.Discard, "", log.Ldate),
+				infoLog:  log.New(io.Discard, "", log.Ldate),
+				cfg:      tc.cfg,
+				messages: tc.messages,
+			}
+
+			_, violations := app.checkPodLabels(pod, tc.ns)
+
+			if len(violations) != 1 {
+				t.Fatalf("checkPodLabels() returned %d violations, want 1", len(violations))
+			}
+
+			if got := violations[0].Message; got != tc.want {
+				t.Errorf("denial message mismatch\nwant=%q\ngot= %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRemediationContact(t *testing.T) {
+
+	teams := NewTeamRegistry()
+	if err := teams.update([]byte(`
+teams:
+  - name: payments
+    aliases: [pay]
+    contacts: ["#payments", "payments@example.com"]
+  - name: platform
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		cfg:   &envConfig{Label: "owner", ContactAnnotation: "example.com/contact", DefaultContact: "#help"},
+		teams: teams,
+	}
+	annotated := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"example.com/contact": "#team-payments"}}}
+
+	tt := []struct {
+		name  string
+		ns    *corev1.Namespace
+		owner string
+		want  string
+	}{
+		{name: "namespace annotation first", ns: annotated, owner: "payments", want: "#team-payments"},
+		{name: "contacts of the team of an alias", ns: &corev1.Namespace{}, owner: "PAY", want: "#payments, payments@example.com"},
+		{name: "team without contacts", ns: &corev1.Namespace{}, owner: "platform", want: "#help"},
+		{name: "unknown team", ns: &corev1.Namespace{}, owner: "platfrom", want: "#help"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := app.remediationContact(tc.ns, tc.owner); got != tc.want {
+				t.Errorf("remediationContact() - got=%q, want=%q", got, tc.want)
+			}
+		})
+	}
+}
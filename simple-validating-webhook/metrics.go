@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"simple-validating-webhook/webhook"
+)
+
+// metrics served at /metrics in the Prometheus text format
+var (
+	scanViolations = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webhook_scan_violations",
+		Help: "Violations found by the last background scan of the existing workloads",
+	}, []string{"namespace", "kind", "rule"})
+
+	scanObjects = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_scan_objects",
+		Help: "Pods and workloads checked by the last background scan",
+	})
+
+	scanDuration = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_scan_duration_seconds",
+		Help: "Duration of the last background scan",
+	})
+
+	scanLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_scan_last_success_timestamp_seconds",
+		Help: "Unix time of the last background scan that completed",
+	})
+
+	scanErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_scan_errors_total",
+		Help: "Background scans that failed and objects that could not be checked",
+	})
+
+	leader = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_leader",
+		Help: "1 while the replica holds the leader election Lease and runs the background work",
+	})
+
+	clientAuthAccepted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_client_auth_accepted_total",
+		Help: "Calls of the admission endpoints and the APIs by authenticated and trusted clients",
+	}, []string{"method"})
+
+	clientAuthRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_client_auth_rejected_total",
+		Help: "Calls of the admission endpoints and the APIs rejected as unauthenticated, untrusted or on an authentication error",
+	}, []string{"reason"})
+
+	admissionShed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_admission_shed_total",
+		Help: "Admission requests answered with the failure policy because the webhook was overloaded",
+	}, []string{"reason"})
+)
+
+// registerLimiterMetrics - exports the requests in flight and in the queue of the limiter to the registry,
+// main registers them once on the default registry
+func registerLimiterMetrics(registry prometheus.Registerer, limiter *webhook.Limiter) {
+
+	factory := promauto.With(registry)
+
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "webhook_admission_inflight",
+		Help: "Admission requests being validated",
+	}, func() float64 { return float64(limiter.InFlight()) })
+
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "webhook_admission_queue_depth",
+		Help: "Admission requests waiting for a free slot",
+	}, func() float64 { return float64(limiter.Queued()) })
+
+	limiter.OnShed(func(reason string) { admissionShed.WithLabelValues(reason).Inc() })
+}
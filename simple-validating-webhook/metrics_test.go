@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"simple-validating-webhook/webhook"
+)
+
+// busyValidator holds its slot of the limiter until release is closed
+type busyValidator struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *busyValidator) Name() string { return "busy" }
+
+func (b *busyValidator) Handles() []webhook.Match {
+	return []webhook.Match{{GVK: schema.GroupVersionKind{Group: webhook.Any, Version: webhook.Any, Kind: webhook.Any}}}
+}
+
+func (b *busyValidator) Validate(ctx context.Context, req *webhook.Request) (webhook.Result, error) {
+	close(b.started)
+	<-b.release
+	return webhook.Result{}, nil
+}
+
+// gaugeValue - returns the value of a gauge from the registry
+func gaugeValue(t *testing.T, registry prometheus.Gatherer, name string) float64 {
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+
+	t.Fatalf("metric %v not registered", name)
+	return 0
+}
+
+func TestAdmissionLoadShedding(t *testing.T) {
+
+	app := &application{
+		errorLog: log.New(io.Discard, "", log.Ldate),
+		infoLog:  log.New(io.Discard, "", log.Ldate),
+		cfg:      &envConfig{Label: "owner", Annotation: "example.com/validate", FailurePolicy: "Fail"},
+		client:   fake.NewSimpleClientset(),
+		limiter:  webhook.NewLimiter(1, 0, 0),
+	}
+	registry := prometheus.NewRegistry()
+	registerLimiterMetrics(registry, app.limiter)
+
+	// the fixture is read before the goroutines start, t.Fatal must run on the test goroutine
+	review, err := os.ReadFile("test-files/admission-request-with-labels.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another endpoint sharing the limiter takes the only slot
+	busy := &busyValidator{started: make(chan struct{}), release: make(chan struct{})}
+	other := webhook.NewServer(nil, nil, nil)
+	other.SetLimiter(app.limiter)
+	other.Register(busy)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		other.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/other", bytes.NewReader(review)))
+	}()
+	<-busy.started
+	defer func() {
+		close(busy.release)
+		<-done
+	}()
+
+	if got := gaugeValue(t, registry, "webhook_admission_inflight"); got != 1 {
+		t.Errorf("webhook_admission_inflight - got=%v, want=1", got)
+	}
+
+	shedBefore := testutil.ToFloat64(admissionShed.WithLabelValues(webhook.ShedQueueFull))
+
+	rr := httptest.NewRecorder()
+	app.setupRoutes().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(review)))
+
+	var response admissionv1.AdmissionReview
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("shed request not answered with an AdmissionReview - %v", err)
+	}
+	if response.Response.Allowed {
+		t.Errorf("shed request allowed with FAILURE_POLICY=Fail")
+	}
+
+	if got := testutil.ToFloat64(admissionShed.WithLabelValues(webhook.ShedQueueFull)) - shedBefore; got != 1 {
+		t.Errorf("webhook_admission_shed_total{reason=queue-full} increase - got=%v, want=1", got)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"simple-validating-webhook/webhook"
+)
+
+// requestID - takes the ID of the request from the X-Request-Id header or generates one, returns it in
+// the response and passes it to the webhook server, which adds it to its log lines
+func (app *application) requestID(next http.Handler) http.Handler {
+
+	return middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		id := middleware.GetReqID(r.Context())
+		w.Header().Set(middleware.RequestIDHeader, id)
+
+		next.ServeHTTP(w, r.WithContext(webhook.WithRequestID(r.Context(), id)))
+	}))
+}
+
+// accessLog - logs every request with its status, size and duration in key=value pairs
+func (app *application) accessLog(next http.Handler) http.Handler {
+
+	if !app.cfg.AccessLog {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			app.infoLog.Printf("access request_id=%v remote=%v method=%v path=%v status=%v bytes=%v duration=%v user_agent=%q",
+				middleware.GetReqID(r.Context()), r.RemoteAddr, r.Method, r.URL.Path, status, ww.BytesWritten(),
+				time.Since(start), r.UserAgent())
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// recoverPanics - answers a request whose handler panicked with 500 Internal Server Error instead of
+// dropping the connection, the webhook server recovers on its own and answers with an AdmissionReview
+func (app *application) recoverPanics(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			app.errorLog.Printf("request_id=%v Recovered from a panic serving %v %v - %v\n%s",
+				middleware.GetReqID(r.Context()), r.Method, r.URL.Path, p, debug.Stack())
+			app.writeErrorMessage(w, "internal error", http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody - fails the reads of the request bodies larger than MAX_REQUEST_BODY_BYTES, the webhook server
+// answers them with 413 Request Entity Too Large before they are held in memory
+func (app *application) limitBody(next http.Handler) http.Handler {
+
+	if app.cfg.MaxRequestBodyBytes <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, app.cfg.MaxRequestBodyBytes)
+		next.ServeHTTP(w, r)
+	})
+}
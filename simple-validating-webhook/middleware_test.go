@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMiddleware(t *testing.T) {
+
+	var infoLogs, errorLogs bytes.Buffer
+	app := &application{
+		errorLog: log.New(&errorLogs, "", 0),
+		infoLog:  log.New(&infoLogs, "", 0),
+		cfg:      &envConfig{AccessLog: true, MaxRequestBodyBytes: 1024},
+	}
+
+	router := app.setupRoutes()
+	router.Get("/panic", func(w http.ResponseWriter, r *http.Request) { panic("handler failed") })
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	tt := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		requestID      string
+		wantStatusCode int
+		wantInfoLog    string
+		wantErrorLog   string
+	}{
+		{
+			name:           "request ID of the caller",
+			method:         http.MethodGet,
+			path:           "/healthz",
+			requestID:      "caller-id-1",
+			wantStatusCode: http.StatusOK,
+			wantInfoLog:    "access request_id=caller-id-1 ",
+		},
+		{
+			name:           "request body larger than the limit",
+			method:         http.MethodPost,
+			path:           "/validate",
+			body:           `{"kind": "AdmissionReview", "padding": "` + strings.Repeat("x", 2048) + `"}`,
+			requestID:      "caller-id-2",
+			wantStatusCode: http.StatusRequestEntityTooLarge,
+			wantInfoLog:    "method=POST path=/validate status=413",
+			wantErrorLog:   "request_id=caller-id-2 Unable to read the POST request",
+		},
+		{
+			name:           "panic in a handler",
+			method:         http.MethodGet,
+			path:           "/panic",
+			wantStatusCode: http.StatusInternalServerError,
+			wantInfoLog:    "path=/panic status=500",
+			wantErrorLog:   "Recovered from a panic serving GET /panic - handler failed",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			infoLogs.Reset()
+			errorLogs.Reset()
+
+			req, err := http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.requestID != "" {
+				req.Header.Set("X-Request-Id", tc.requestID)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+
+			if res.StatusCode != tc.wantStatusCode {
+				t.Errorf("HTTP status code mismatch want=%v, got=%v", tc.wantStatusCode, res.StatusCode)
+			}
+
+			id := res.Header.Get("X-Request-Id")
+			if id == "" || (tc.requestID != "" && id != tc.requestID) {
+				t.Errorf("X-Request-Id of the response - got=%q, want=%q or a generated ID", id, tc.requestID)
+			}
+
+			if !strings.Contains(infoLogs.String(), tc.wantInfoLog) {
+				t.Errorf("access log does not contain %q\n%s", tc.wantInfoLog, infoLogs.String())
+			}
+			if !strings.Contains(errorLogs.String(), tc.wantErrorLog) {
+				t.Errorf("error log does not contain %q\n%s", tc.wantErrorLog, errorLogs.String())
+			}
+		})
+	}
+}
+
+func TestRequestIDInApplicationLogs(t *testing.T) {
+
+	var errorLogs bytes.Buffer
+	app := &application{
+		errorLog: log.New(&errorLogs, "", 0),
+		infoLog:  log.New(io.Discard, "", 0),
+		cfg:      &envConfig{Label: "owner", Annotation: "example.com/validate"},
+		client:   fake.NewSimpleClientset(),
+	}
+
+	f, err := os.Open("test-files/admission-request-with-labels.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// the namespace of the Pod does not exist, the failed lookup is logged with the ID of the request
+	req := httptest.NewRequest(http.MethodPost, "/validate", f)
+	req.Header.Set("X-Request-Id", "caller-id-3")
+	app.setupRoutes().ServeHTTP(httptest.NewRecorder(), req)
+
+	if want := "request_id=caller-id-3 error checking annotations on the namespace webhook-demo"; !strings.Contains(errorLogs.String(), want) {
+		t.Errorf("error log does not contain %q\n%s", want, errorLogs.String())
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"simple-validating-webhook/webhook"
+)
+
+// namespace modes supported by NAMESPACE_MODE
+const (
+	// namespaceModeOptIn - only namespaces with the annotation set to true, or matching
+	// the NAMESPACE_SELECTOR, are validated
+	namespaceModeOptIn = "opt-in"
+	// namespaceModeOptOut - every namespace is validated, unless it has the annotation set
+	// to false or matches the NAMESPACE_SELECTOR
+	namespaceModeOptOut = "opt-out"
+)
+
+// ParseNamespaceSelector - parses a label selector expression such as "env in (prod,staging),!legacy",
+// returns a nil selector when the expression is empty
+func ParseNamespaceSelector(expr string) (labels.Selector, error) {
+
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+
+	selector, err := labels.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace selector %q - %v", expr, err)
+	}
+
+	return selector, nil
+}
+
+// ValidateNamespaceMode - returns an error if the mode is not one of the supported namespace modes
+func ValidateNamespaceMode(mode string) error {
+
+	switch mode {
+	case namespaceModeOptIn, namespaceModeOptOut:
+		return nil
+	default:
+		return fmt.Errorf("invalid namespace mode %q, must be %q or %q", mode, namespaceModeOptIn, namespaceModeOptOut)
+	}
+}
+
+// namespaceEnforced - returns true if the objects in the namespace have to be validated
+func (app *application) namespaceEnforced(ns *corev1.Namespace) bool {
+
+	selected := app.selector != nil && app.selector.Matches(labels.Set(ns.GetLabels()))
+
+	if app.cfg.NamespaceMode == namespaceModeOptOut {
+		// the annotation has to be explicitly set to false to disable the validation
+		if val, ok := ns.GetAnnotations()[app.cfg.Annotation]; ok && strings.ToLower(val) == "false" {
+			return false
+		}
+		return !selected
+	}
+
+	return selected || app.namespaceAnnotationTrue(ns, app.cfg.Annotation)
+}
+
+// skipReason - explains why the validation was skipped for a namespace that is not enforced
+func (app *application) skipReason() string {
+
+	if app.cfg.NamespaceMode == namespaceModeOptOut {
+		return "skipping validation as annotation Key " + app.cfg.Annotation +
+			" is set to false on the namespace or the namespace matches the selector " + app.cfg.NamespaceSelector
+	}
+
+	if app.selector != nil {
+		return "skipping validation as annotation Key " + app.cfg.Annotation +
+			" is missing or set to false on the namespace and the namespace does not match the selector " + app.cfg.NamespaceSelector
+	}
+
+	return "skipping validation as annotation Key " + app.cfg.Annotation + " is missing or set to false on the namespace"
+}
+
+// checkNamespaceMetadata - checks that a new namespace has the labels and annotations listed in
+// NAMESPACE_REQUIRED_LABELS and NAMESPACE_REQUIRED_ANNOTATIONS set to a non-empty value
+func (app *application) checkNamespaceMetadata(ns *corev1.Namespace) webhook.Violations {
+
+	var violations webhook.Violations
+
+	data := messageData{
+		Kind:    "Namespace",
+		Name:    ns.Name,
+		Contact: app.remediationContact(ns, ns.Labels[app.cfg.Label]),
+	}
+
+	for _, key := range app.cfg.NamespaceRequiredLabels {
+		val, ok := ns.GetLabels()[key]
+
+		rule := ruleMissingLabel
+		if ok {
+			if val != "" {
+				continue
+			}
+			rule = ruleEmptyLabel
+		}
+
+		d := data
+		d.Label = key
+		violations = append(violations, webhook.Violation{Rule: rule, Field: key, Message: app.denialMessage(rule, d)})
+	}
+
+	for _, key := range app.cfg.NamespaceRequiredAnnotations {
+		if val := ns.GetAnnotations()[key]; val != "" {
+			continue
+		}
+
+		d := data
+		d.Annotation = key
+		violations = append(violations, webhook.Violation{
+			Rule:    ruleMissingAnnotation,
+			Field:   key,
+			Message: app.denialMessage(ruleMissingAnnotation, d),
+		})
+	}
+
+	return violations
+}
+
+// checkNamespaceEnforcementChange - denies non-admin users an update that stops the validation of a namespace,
+// e.g. flipping the annotation off or removing the labels matched by the selector
+func (app *application) checkNamespaceEnforcementChange(oldNs, ns *corev1.Namespace, user authenticationv1.UserInfo) webhook.Violations {
+
+	if !app.namespaceEnforced(oldNs) || app.namespaceEnforced(ns) || app.isAdmin(user) {
+		return nil
+	}
+
+	data := messageData{
+		Kind:       "Namespace",
+		Name:       ns.Name,
+		Annotation: app.cfg.Annotation,
+		Contact:    app.remediationContact(oldNs, oldNs.Labels[app.cfg.Label]),
+	}
+
+	return webhook.Violations{{
+		Rule:    ruleEnforcementDisabled,
+		Field:   app.cfg.Annotation,
+		Message: app.denialMessage(ruleEnforcementDisabled, data),
+	}}
+}
+
+// isAdmin - returns true if the user is listed in ADMIN_USERS or is a member of one of the ADMIN_GROUPS
+func (app *application) isAdmin(user authenticationv1.UserInfo) bool {
+
+	for _, name := range app.cfg.AdminUsers {
+		if name == user.Username {
+			return true
+		}
+	}
+
+	for _, group := range user.Groups {
+		for _, adminGroup := range app.cfg.AdminGroups {
+			if group == adminGroup {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseNamespaceSelector(t *testing.T) {
+
+	tt := []struct {
+		name    string
+		expr    string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "empty selector", expr: "", wantNil: true},
+		{name: "set based selector", expr: "env in (prod,staging),!legacy"},
+		{name: "equality based selector", expr: "team=payments"},
+		{name: "invalid selector", expr: "env in (prod", wantErr: true, wantNil: true},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+
+			got, err := ParseNamespaceSelector(tc.expr)
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseNamespaceSelector() error = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if (got == nil) != tc.wantNil {
+				t.Errorf("ParseNamespaceSelector() returned selector %v, want nil=%v", got, tc.wantNil)
+			}
+		})
+	}
+}
+
+func TestNamespaceEnforced(t *testing.T) {
+
+	tt := []struct {
+		name        string
+		mode        string
+		selector    string
+		labels      map[string]string
+		annotations map[string]string
+		want        bool
+	}{
+		{
+			name:        "opt-in with annotation set to true",
+			mode:        namespaceModeOptIn,
+			annotations: map[string]string{"example.com/validate": "true"},
+			want:        true,
+		},
+		{
+			name: "opt-in without annotation or selector",
+			mode: namespaceModeOptIn,
+			want: false,
+		},
+		{
+			name:     "opt-in with matching selector",
+			mode:     namespaceModeOptIn,
+			selector: "env in (prod,staging),!legacy",
+			labels:   map[string]string{"env": "prod"},
+			want:     true,
+		},
+		{
+			name:     "opt-in with selector excluding legacy namespaces",
+			mode:     namespaceModeOptIn,
+			selector: "env in (prod,staging),!legacy",
+			labels:   map[string]string{"env": "prod", "legacy": "yes"},
+			want:     false,
+		},
+		{
+			name: "opt-out without annotation",
+			mode: namespaceModeOptOut,
+			want: true,
+		},
+		{
+			name:        "opt-out with annotation set to false",
+			mode:        namespaceModeOptOut,
+			annotations: map[string]string{"example.com/validate": "False"},
+			want:        false,
+		},
+		{
+			name:     "opt-out with matching selector",
+			mode:     namespaceModeOptOut,
+			selector: "legacy",
+			labels:   map[string]string{"legacy": "yes"},
+			want:     false,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+
+			selector, err := ParseNamespaceSelector(tc.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			app := &application{
+				errorLog: log.New(io.Discard, "", log.Ldate),
+				infoLog:  log.New(io.Discard, "", log.Ldate),
+				cfg: &envConfig{
+					Annotation:        "example.com/validate",
+					NamespaceSelector: tc.selector,
+					NamespaceMode:     tc.mode,
+				},
+				selector: selector,
+			}
+
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-namespace",
+					Labels:      tc.labels,
+					Annotations: tc.annotations,
+				},
+			}
+
+			if got := app.namespaceEnforced(ns); got != tc.want {
+				t.Errorf("namespaceEnforced() got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+}
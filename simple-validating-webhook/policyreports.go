@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+
+	"simple-validating-webhook/webhook"
+)
+
+const (
+	// policyReportName - name of the PolicyReport of every namespace and of the ClusterPolicyReport
+	policyReportName = "simple-validating-webhook"
+	// policyReportSource - source of the results, dashboards group the results of a tool by it
+	policyReportSource = "simple-validating-webhook"
+	// policyReportTimeout - upper bound of the API calls that write a report
+	policyReportTimeout = 10 * time.Second
+	// maxAdmissionResources - denied objects kept in the report of a namespace, the oldest are dropped
+	maxAdmissionResources = 500
+
+	// categories of the results, the denials of the webhook and the findings of the background scan
+	policyCategoryAdmission  = "admission"
+	policyCategoryBackground = "background"
+)
+
+var (
+	policyReportGVR        = schema.GroupVersionResource{Group: "wgpolicyk8s.io", Version: "v1alpha2", Resource: "policyreports"}
+	clusterPolicyReportGVR = schema.GroupVersionResource{Group: "wgpolicyk8s.io", Version: "v1alpha2", Resource: "clusterpolicyreports"}
+)
+
+// policyResult is a result of a PolicyReport, a violation of a rule by a resource
+type policyResult struct {
+	Source     string                   `json:"source"`
+	Policy     string                   `json:"policy"`
+	Rule       string                   `json:"rule,omitempty"`
+	Result     string                   `json:"result"`
+	Message    string                   `json:"message,omitempty"`
+	Category   string                   `json:"category,omitempty"`
+	Timestamp  policyTimestamp          `json:"timestamp"`
+	Resources  []corev1.ObjectReference `json:"resources"`
+	Properties map[string]string        `json:"properties,omitempty"`
+}
+
+type policyTimestamp struct {
+	Seconds int64 `json:"seconds"`
+	Nanos   int32 `json:"nanos"`
+}
+
+// policySummary counts the results of a report by result
+type policySummary struct {
+	Pass  int `json:"pass"`
+	Fail  int `json:"fail"`
+	Warn  int `json:"warn"`
+	Error int `json:"error"`
+	Skip  int `json:"skip"`
+}
+
+// policyReport is a PolicyReport, or a ClusterPolicyReport for the cluster scoped resources
+type policyReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Summary           policySummary  `json:"summary"`
+	Results           []policyResult `json:"results"`
+}
+
+// policyReporter writes the violations found by the webhook and by the background scan into a PolicyReport
+// per namespace and a ClusterPolicyReport. Every replica merges the changes it saw since the last flush into
+// the stored reports, the reports are the only state shared by the replicas
+type policyReporter struct {
+	client   dynamic.Interface
+	infoLog  *log.Logger
+	errorLog *log.Logger
+
+	mu        sync.Mutex
+	pending   map[string]*pendingResults // namespace -> changes not written yet
+	scanned   map[string]bool            // namespaces with findings in the last scan
+	reconcile bool                       // a scan was recorded, the reports of the other namespaces lose their findings
+}
+
+// pendingResults are the changes of the report of a namespace since the last flush
+type pendingResults struct {
+	admission   map[string][]policyResult // resource -> results of the last denial, nil once allowed
+	scan        []policyResult            // findings of the last scan
+	replaceScan bool                      // the findings of the report are replaced with scan
+}
+
+// NewPolicyReporter - returns a reporter that writes the reports with the dynamic client, the cluster
+// scoped resources are reported in the namespace ""
+func NewPolicyReporter(client dynamic.Interface, infoLog, errorLog *log.Logger) *policyReporter {
+	return &policyReporter{
+		client:   client,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		pending:  map[string]*pendingResults{},
+		scanned:  map[string]bool{},
+	}
+}
+
+// violationResults - returns a failed result for each violation of the resource
+func violationResults(violations webhook.Violations, resource corev1.ObjectReference, category string, t time.Time) []policyResult {
+
+	results := make([]policyResult, 0, len(violations))
+	for _, v := range violations {
+
+		rule := v.Field
+		if rule == "" {
+			rule = v.Rule
+		}
+
+		result := policyResult{
+			Source:    policyReportSource,
+			Policy:    v.Rule,
+			Rule:      rule,
+			Result:    "fail",
+			Message:   v.Message,
+			Category:  category,
+			Timestamp: policyTimestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())},
+			Resources: []corev1.ObjectReference{resource},
+		}
+		if v.Field != "" {
+			result.Properties = map[string]string{"field": v.Field, "value": v.Value}
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// resourceKey - returns the key of the resource of a result, objects created with generateName have no
+// name yet and share the key of their generateName, a name can not contain the *
+func resourceKey(result policyResult) string {
+
+	if result.Resources[0].Name == "" {
+		return result.Resources[0].Kind + "/" + result.Properties["generateName"] + "*"
+	}
+
+	return result.Resources[0].Kind + "/" + result.Resources[0].Name
+}
+
+// generateName - returns the generateName of the object of a request without a name, empty when the
+// object has none
+func generateName(req *webhook.Request) string {
+
+	var meta metav1.PartialObjectMetadata
+	if err := req.DecodeObject(&meta); err != nil {
+		return ""
+	}
+
+	return meta.GenerateName
+}
+
+// pendingFor - returns the pending changes of the namespace, r.mu must be held
+func (r *policyReporter) pendingFor(namespace string) *pendingResults {
+
+	p := r.pending[namespace]
+	if p == nil {
+		p = &pendingResults{admission: map[string][]policyResult{}}
+		r.pending[namespace] = p
+	}
+
+	return p
+}
+
+// recordAdmission - keeps the violations of a denied request until the resource is allowed, an allowed
+// request removes the denial of the resource written by any replica. The request ID of the context is kept
+// in the properties of the results to find the log lines of the denial. The objects created with generateName
+// keep the last denial of their generateName, e.g. of the Pods of a Job, the other objects without a name
+// are not reported
+func (r *policyReporter) recordAdmission(ctx context.Context, req *webhook.Request, result webhook.Result, t time.Time) {
+
+	key := req.Kind.Kind + "/" + req.Name
+	properties := map[string]string{}
+	if req.Name == "" {
+		prefix := generateName(req)
+		if prefix == "" {
+			return
+		}
+		key = req.Kind.Kind + "/" + prefix + "*"
+		properties["generateName"] = prefix
+	}
+
+	// Namespace objects are reported in the ClusterPolicyReport
+	namespace := req.Namespace
+	if req.Kind.Kind == namespaceKind.Kind {
+		namespace = ""
+	}
+
+	var results []policyResult
+	if !result.Allowed() {
+		results = violationResults(result.Violations, corev1.ObjectReference{
+			APIVersion: schema.GroupVersion{Group: req.Kind.Group, Version: req.Kind.Version}.String(),
+			Kind:       req.Kind.Kind,
+			Name:       req.Name,
+			Namespace:  req.Namespace,
+		}, policyCategoryAdmission, t)
+		if id := webhook.RequestID(ctx); id != "" {
+			properties["request_id"] = id
+		}
+		for i := range results {
+			for k, v := range properties {
+				if results[i].Properties == nil {
+					results[i].Properties = map[string]string{}
+				}
+				results[i].Properties[k] = v
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pendingFor(namespace).admission[key] = results
+}
+
+// recordScan - replaces the findings of the previous scan with the findings of the report, only the
+// replica running the scan records them
+func (r *policyReporter) recordScan(report *scanReport) {
+
+	scan := map[string][]policyResult{}
+	for _, f := range report.Findings {
+		scan[f.Namespace] = append(scan[f.Namespace], violationResults(f.Violations, corev1.ObjectReference{
+			APIVersion: f.APIVersion,
+			Kind:       f.Kind,
+			Name:       f.Name,
+			Namespace:  f.Namespace,
+			UID:        f.UID,
+		}, policyCategoryBackground, report.Finished)...)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.scanned = map[string]bool{}
+	for namespace, results := range scan {
+		p := r.pendingFor(namespace)
+		p.scan, p.replaceScan = results, true
+		r.scanned[namespace] = true
+	}
+	r.reconcile = true
+}
+
+// mergeResults - applies the pending changes to the results of the stored report and returns the results
+// sorted by resource and policy, the oldest denials over maxAdmissionResources are dropped
+func mergeResults(stored []policyResult, p *pendingResults) []policyResult {
+
+	results := []policyResult{}
+	for _, result := range stored {
+		if result.Category == policyCategoryBackground && p.replaceScan {
+			continue
+		}
+		if _, changed := p.admission[resourceKey(result)]; changed && result.Category == policyCategoryAdmission {
+			continue
+		}
+		results = append(results, result)
+	}
+	if p.replaceScan {
+		results = append(results, p.scan...)
+	}
+	for _, denial := range p.admission {
+		results = append(results, denial...)
+	}
+
+	// drop the oldest denials of the namespace
+	denied := map[string]policyTimestamp{}
+	for _, result := range results {
+		if result.Category == policyCategoryAdmission {
+			denied[resourceKey(result)] = result.Timestamp
+		}
+	}
+	if len(denied) > maxAdmissionResources {
+		keys := make([]string, 0, len(denied))
+		for key := range denied {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := denied[keys[i]], denied[keys[j]]
+			return a.Seconds < b.Seconds || (a.Seconds == b.Seconds && a.Nanos < b.Nanos)
+		})
+		dropped := map[string]bool{}
+		for _, key := range keys[:len(keys)-maxAdmissionResources] {
+			dropped[key] = true
+		}
+
+		kept := results[:0]
+		for _, result := range results {
+			if result.Category != policyCategoryAdmission || !dropped[resourceKey(result)] {
+				kept = append(kept, result)
+			}
+		}
+		results = kept
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Resources[0].Kind != b.Resources[0].Kind {
+			return a.Resources[0].Kind < b.Resources[0].Kind
+		}
+		if a.Resources[0].Name != b.Resources[0].Name {
+			return a.Resources[0].Name < b.Resources[0].Name
+		}
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		return a.Policy < b.Policy
+	})
+
+	return results
+}
+
+// report - returns the report of the namespace with the results
+func (r *policyReporter) report(namespace string, results []policyResult) *policyReport {
+
+	report := &policyReport{
+		TypeMeta: metav1.TypeMeta{APIVersion: "wgpolicyk8s.io/v1alpha2", Kind: "PolicyReport"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      policyReportName,
+			Namespace: namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": policyReportSource},
+		},
+		Results: results,
+	}
+	if namespace == "" {
+		report.Kind = "ClusterPolicyReport"
+	}
+
+	for _, result := range report.Results {
+		switch result.Result {
+		case "pass":
+			report.Summary.Pass++
+		case "fail":
+			report.Summary.Fail++
+		case "warn":
+			report.Summary.Warn++
+		case "error":
+			report.Summary.Error++
+		case "skip":
+			report.Summary.Skip++
+		}
+	}
+
+	return report
+}
+
+// run - writes the changes every interval until the context is cancelled, the changes recorded until then
+// are written before it returns
+func (r *policyReporter) run(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.flush(context.Background())
+			return
+		case <-ticker.C:
+			r.flush(ctx)
+		}
+	}
+}
+
+// flush - merges the changes into the stored reports, the failed ones are retried by the next flush
+func (r *policyReporter) flush(ctx context.Context) {
+
+	r.mu.Lock()
+	pending, reconcile := r.pending, r.reconcile
+	r.pending, r.reconcile = map[string]*pendingResults{}, false
+	scanned := r.scanned
+	r.mu.Unlock()
+
+	if len(pending) == 0 && !reconcile {
+		return
+	}
+
+	stored, err := r.list(ctx)
+	if err != nil {
+		r.errorLog.Printf("error listing the PolicyReports - %v", err)
+		for namespace, p := range pending {
+			r.requeue(namespace, p)
+		}
+		r.mu.Lock()
+		r.reconcile = r.reconcile || reconcile
+		r.mu.Unlock()
+		return
+	}
+
+	// the findings of the previous scans are removed from the namespaces without findings in the last one
+	if reconcile {
+		for namespace := range stored {
+			if scanned[namespace] {
+				continue
+			}
+			if pending[namespace] == nil {
+				pending[namespace] = &pendingResults{}
+			}
+			pending[namespace].scan, pending[namespace].replaceScan = nil, true
+		}
+	}
+
+	for namespace, p := range pending {
+		if err := r.write(ctx, namespace, p, stored[namespace]); err != nil {
+			r.errorLog.Printf("error writing the report of the namespace %q - %v", namespace, err)
+			r.requeue(namespace, p)
+		}
+	}
+}
+
+// requeue - keeps the changes of a failed write for the next flush unless newer ones were recorded
+func (r *policyReporter) requeue(namespace string, p *pendingResults) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := r.pendingFor(namespace)
+	for key, results := range p.admission {
+		if _, newer := current.admission[key]; !newer {
+			current.admission[key] = results
+		}
+	}
+	if p.replaceScan && !current.replaceScan && !r.reconcile {
+		current.scan, current.replaceScan = p.scan, true
+	}
+}
+
+// list - returns the stored reports by namespace, the ClusterPolicyReport in the namespace ""
+func (r *policyReporter) list(ctx context.Context) (map[string]*unstructured.Unstructured, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, policyReportTimeout)
+	defer cancel()
+
+	opts := metav1.ListOptions{LabelSelector: "app.kubernetes.io/managed-by=" + policyReportSource}
+	stored := map[string]*unstructured.Unstructured{}
+
+	for _, gvr := range []schema.GroupVersionResource{policyReportGVR, clusterPolicyReportGVR} {
+		list, err := r.client.Resource(gvr).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			if list.Items[i].GetName() == policyReportName {
+				stored[list.Items[i].GetNamespace()] = &list.Items[i]
+			}
+		}
+	}
+
+	return stored, nil
+}
+
+// write - merges the changes into the stored report of the namespace, nil when it does not exist yet. A
+// report without results is only created once it has results, a report changed by another replica in the
+// meantime is read again
+func (r *policyReporter) write(ctx context.Context, namespace string, p *pendingResults, stored *unstructured.Unstructured) error {
+
+	ctx, cancel := context.WithTimeout(ctx, policyReportTimeout)
+	defer cancel()
+
+	var client dynamic.ResourceInterface = r.client.Resource(clusterPolicyReportGVR)
+	if namespace != "" {
+		client = r.client.Resource(policyReportGVR).Namespace(namespace)
+	}
+
+	conflict := func(err error) bool { return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) }
+	attempt := 0
+
+	return retry.OnError(retry.DefaultRetry, conflict, func() error {
+
+		if attempt++; attempt > 1 {
+			var err error
+			if stored, err = client.Get(ctx, policyReportName, metav1.GetOptions{}); apierrors.IsNotFound(err) {
+				stored = nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		var current policyReport
+		if stored != nil {
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(stored.Object, &current); err != nil {
+				return err
+			}
+		}
+
+		results := mergeResults(current.Results, p)
+		if stored == nil && len(results) == 0 {
+			return nil
+		}
+		if stored != nil && equality.Semantic.DeepEqual(results, mergeResults(current.Results, &pendingResults{})) {
+			return nil
+		}
+
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(r.report(namespace, results))
+		if err != nil {
+			return err
+		}
+		obj := &unstructured.Unstructured{Object: content}
+
+		if stored == nil {
+			_, err = client.Create(ctx, obj, metav1.CreateOptions{})
+			return err
+		}
+
+		obj.SetResourceVersion(stored.GetResourceVersion())
+		_, err = client.Update(ctx, obj, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// recordPolicyResults - side effect that keeps the violations of a denied request for the PolicyReports
+func (app *application) recordPolicyResults(ctx context.Context, req *webhook.Request, result webhook.Result) {
+	app.reports.recordAdmission(ctx, req, result, time.Now())
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"simple-validating-webhook/webhook"
+)
+
+// newPolicyTestRequest - returns a CREATE request of an object
+func newPolicyTestRequest(kind, namespace, name string) *webhook.Request {
+	return webhook.NewRequest(&admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+		UID:       "705ab4f5-6393-11e8-b7cc-42010a800002",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: kind},
+		Namespace: namespace,
+		Name:      name,
+		Operation: admissionv1.Create,
+	}}, nil)
+}
+
+// getPolicyReport - returns the summary and the number of results of a report
+func getPolicyReport(t *testing.T, r *policyReporter, gvr schema.GroupVersionResource, namespace string) (int64, int) {
+
+	obj, err := r.client.Resource(gvr).Namespace(namespace).Get(context.Background(), policyReportName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fail, _, _ := unstructured.NestedInt64(obj.Object, "summary", "fail")
+	results, _, _ := unstructured.NestedSlice(obj.Object, "results")
+
+	return fail, len(results)
+}
+
+func TestPolicyReporter(t *testing.T) {
+
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		policyReportGVR:        "PolicyReportList",
+		clusterPolicyReportGVR: "ClusterPolicyReportList",
+	})
+	r := NewPolicyReporter(client, log.New(io.Discard, "", 0), log.New(io.Discard, "", 0))
+
+	ctx := context.Background()
+	now := time.Now()
+	denied := webhook.Result{Violations: webhook.Violations{{Rule: ruleMissingLabel, Field: "owner", Message: "missing owner"}}}
+
+	// a denied Pod and a finding of the background scan in team-a, a denied Namespace
+	r.recordAdmission(ctx, newPolicyTestRequest("Pod", "team-a", "bare"), denied, now)
+	r.recordAdmission(ctx, newPolicyTestRequest("Namespace", "team-b", "team-b"), denied, now)
+	r.recordScan(&scanReport{Finished: now, Findings: []scanFinding{
+		{Namespace: "team-a", APIVersion: "apps/v1", Kind: "Deployment", Name: "web", Violations: denied.Violations},
+	}})
+	r.flush(ctx)
+
+	if fail, results := getPolicyReport(t, r, policyReportGVR, "team-a"); fail != 2 || results != 2 {
+		t.Errorf("PolicyReport of team-a - got fail=%v results=%v, want 2 and 2", fail, results)
+	}
+	if fail, results := getPolicyReport(t, r, clusterPolicyReportGVR, ""); fail != 1 || results != 1 {
+		t.Errorf("ClusterPolicyReport - got fail=%v results=%v, want 1 and 1", fail, results)
+	}
+
+	// the Pod is allowed once fixed and the next scan finds nothing, the report is kept with an empty summary
+	r.recordAdmission(ctx, newPolicyTestRequest("Pod", "team-a", "bare"), webhook.Result{}, now)
+	r.recordScan(&scanReport{Finished: now})
+	r.flush(ctx)
+
+	if fail, results := getPolicyReport(t, r, policyReportGVR, "team-a"); fail != 0 || results != 0 {
+		t.Errorf("PolicyReport of team-a after the fix - got fail=%v results=%v, want 0 and 0", fail, results)
+	}
+
+	// allowed requests in a namespace without a report do not create one
+	r.recordAdmission(ctx, newPolicyTestRequest("Pod", "team-c", "labelled"), webhook.Result{}, now)
+	r.flush(ctx)
+
+	if _, err := client.Resource(policyReportGVR).Namespace("team-c").Get(ctx, policyReportName, metav1.GetOptions{}); err == nil {
+		t.Errorf("PolicyReport created for a namespace without violations")
+	}
+}
+
+func TestPolicyReportsMergeTheResultsOfTheReplicas(t *testing.T) {
+
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		policyReportGVR:        "PolicyReportList",
+		clusterPolicyReportGVR: "ClusterPolicyReportList",
+	})
+	leader := NewPolicyReporter(client, log.New(io.Discard, "", 0), log.New(io.Discard, "", 0))
+	follower := NewPolicyReporter(client, log.New(io.Discard, "", 0), log.New(io.Discard, "", 0))
+
+	ctx := context.Background()
+	now := time.Now()
+	denied := webhook.Result{Violations: webhook.Violations{{Rule: ruleMissingLabel, Field: "owner", Message: "missing owner"}}}
+
+	// each replica writes its own denials, the findings of the scan of the leader are kept by the follower
+	leader.recordAdmission(ctx, newPolicyTestRequest("Pod", "team-a", "first"), denied, now)
+	leader.recordScan(&scanReport{Finished: now, Findings: []scanFinding{
+		{Namespace: "team-a", APIVersion: "apps/v1", Kind: "Deployment", Name: "web", Violations: denied.Violations},
+	}})
+	leader.flush(ctx)
+	follower.recordAdmission(ctx, newPolicyTestRequest("Pod", "team-a", "second"), denied, now)
+	follower.flush(ctx)
+
+	if fail, results := getPolicyReport(t, leader, policyReportGVR, "team-a"); fail != 3 || results != 3 {
+		t.Errorf("PolicyReport of team-a - got fail=%v results=%v, want 3 and 3", fail, results)
+	}
+
+	// the follower allows the Pod denied by the leader
+	follower.recordAdmission(ctx, newPolicyTestRequest("Pod", "team-a", "first"), webhook.Result{}, now)
+	follower.flush(ctx)
+
+	if fail, results := getPolicyReport(t, leader, policyReportGVR, "team-a"); fail != 2 || results != 2 {
+		t.Errorf("PolicyReport of team-a after the fix - got fail=%v results=%v, want 2 and 2", fail, results)
+	}
+}
+
+func TestPolicyReportsKeepTheNewestDenials(t *testing.T) {
+
+	r := NewPolicyReporter(nil, log.New(io.Discard, "", 0), log.New(io.Discard, "", 0))
+	denied := webhook.Result{Violations: webhook.Violations{{Rule: ruleMissingLabel, Message: "missing owner"}}}
+	start := time.Now()
+
+	for i := 0; i <= maxAdmissionResources; i++ {
+		req := newPolicyTestRequest("Pod", "team-a", fmt.Sprintf("pod-%d", i))
+		r.recordAdmission(context.Background(), req, denied, start.Add(time.Duration(i)*time.Second))
+	}
+
+	results := mergeResults(nil, r.pending["team-a"])
+	if len(results) != maxAdmissionResources {
+		t.Fatalf("denied resources - got=%v, want=%v", len(results), maxAdmissionResources)
+	}
+	for _, result := range results {
+		if result.Resources[0].Name == "pod-0" {
+			t.Errorf("the oldest denial was kept")
+		}
+	}
+}
+
+func TestPolicyReportsKeyUnnamedObjectsByGenerateName(t *testing.T) {
+
+	r := NewPolicyReporter(nil, log.New(io.Discard, "", 0), log.New(io.Discard, "", 0))
+	denied := webhook.Result{Violations: webhook.Violations{{Rule: ruleMissingLabel, Message: "missing owner"}}}
+	now := time.Now()
+
+	unnamed := func(uid, object string) *webhook.Request {
+		req := newPolicyTestRequest("Pod", "team-a", "")
+		req.UID = types.UID(uid)
+		req.Object.Raw = []byte(object)
+		return req
+	}
+
+	// the Pods of a Job share their generateName, an unnamed object without one is not reported
+	r.recordAdmission(context.Background(), unnamed("1", `{"metadata":{"generateName":"job-"}}`), denied, now)
+	r.recordAdmission(context.Background(), unnamed("2", `{"metadata":{"generateName":"job-"}}`), denied, now)
+	r.recordAdmission(context.Background(), unnamed("3", `{"metadata":{}}`), denied, now)
+
+	results := mergeResults(nil, r.pending["team-a"])
+	if len(results) != 1 || results[0].Properties["generateName"] != "job-" {
+		t.Fatalf("results of the unnamed Pods - got=%+v, want one of the generateName job-", results)
+	}
+
+	// a Pod of the generateName that is allowed removes the denial
+	r.recordAdmission(context.Background(), unnamed("4", `{"metadata":{"generateName":"job-"}}`), webhook.Result{}, now)
+
+	if results := mergeResults(results, r.pending["team-a"]); len(results) != 0 {
+		t.Errorf("results after the allowed Pod - got=%v, want none", len(results))
+	}
+}
+
+func TestPolicyReportSummary(t *testing.T) {
+
+	r := NewPolicyReporter(nil, log.New(io.Discard, "", 0), log.New(io.Discard, "", 0))
+
+	report := r.report("team-a", []policyResult{{Result: "fail"}, {Result: "fail"}, {Result: "warn"}, {Result: "pass"}, {Result: "error"}})
+
+	if want := (policySummary{Pass: 1, Fail: 2, Warn: 1, Error: 1}); report.Summary != want {
+		t.Errorf("summary - got=%+v, want=%+v", report.Summary, want)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/rego"
+	admissionv1 "k8s.io/api/admission/v1"
+
+	"simple-validating-webhook/webhook"
+)
+
+// regoDecision is the result of the Rego policy mapped onto the admission response
+type regoDecision struct {
+	Allowed  bool
+	Deny     []string
+	Warnings []string
+}
+
+// regoPolicy holds the prepared query of the Rego policies loaded from a directory, the policies
+// are reloaded in place when the files in the directory change
+type regoPolicy struct {
+	dir   string
+	query string
+
+	mu          sync.RWMutex
+	prepared    rego.PreparedEvalQuery
+	fingerprint string
+}
+
+// regoQuery - converts a decision path such as "kubernetes/admission/decision" into the query
+// "data.kubernetes.admission.decision", queries that already start with data are returned as is
+func regoQuery(path string) string {
+
+	path = strings.Trim(path, "/ ")
+	if path == "data" || strings.HasPrefix(path, "data.") {
+		return path
+	}
+
+	return "data." + strings.ReplaceAll(path, "/", ".")
+}
+
+// NewRegoPolicy - loads and compiles the .rego files and the data files in the directory, the
+// decision path is queried with the AdmissionReview as input
+func NewRegoPolicy(ctx context.Context, dir, decisionPath string) (*regoPolicy, error) {
+
+	p := &regoPolicy{dir: dir, query: regoQuery(decisionPath)}
+
+	if _, err := p.reload(ctx); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// dirFingerprint - returns a value that changes when a file in the directory is added, removed or modified
+func dirFingerprint(dir string) (string, error) {
+
+	var entries []string
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano()))
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(entries)
+
+	return strings.Join(entries, "\n"), nil
+}
+
+// reload - recompiles the policies if the files in the directory changed, the previous policies are
+// kept if the new ones do not compile, returns true if the policies were reloaded
+func (p *regoPolicy) reload(ctx context.Context) (bool, error) {
+
+	fingerprint, err := dirFingerprint(p.dir)
+	if err != nil {
+		return false, fmt.Errorf("error reading the Rego policy directory %v - %v", p.dir, err)
+	}
+
+	p.mu.RLock()
+	unchanged := p.fingerprint != "" && p.fingerprint == fingerprint
+	p.mu.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	prepared, err := rego.New(
+		rego.Query(p.query),
+		rego.Load([]string{p.dir}, nil),
+	).PrepareForEval(ctx)
+
+	if err != nil {
+		return false, fmt.Errorf("error compiling the Rego policies in %v - %v", p.dir, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prepared, p.fingerprint = prepared, fingerprint
+
+	return true, nil
+}
+
+// loaded - returns true once the policies have been compiled
+func (p *regoPolicy) loaded() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.fingerprint != ""
+}
+
+// watch - reloads the policies every interval until the context is cancelled
+func (p *regoPolicy) watch(ctx context.Context, interval time.Duration, infoLog, errorLog *log.Logger) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := p.reload(ctx)
+			if err != nil {
+				errorLog.Printf("%v, keeping the previous policies", err)
+				continue
+			}
+			if reloaded {
+				infoLog.Printf("Reloaded the Rego policies from %v", p.dir)
+			}
+		}
+	}
+}
+
+// evaluate - queries the decision path with the AdmissionReview as input, an undefined decision
+// means that the policies have no opinion and the request is allowed
+func (p *regoPolicy) evaluate(ctx context.Context, review admissionv1.AdmissionReview) (regoDecision, error) {
+
+	input, err := toJSONMap(review)
+	if err != nil {
+		return regoDecision{}, fmt.Errorf("error converting the AdmissionReview - %v", err)
+	}
+
+	p.mu.RLock()
+	prepared := p.prepared
+	p.mu.RUnlock()
+
+	rs, err := prepared.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return regoDecision{}, err
+	}
+
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return regoDecision{Allowed: true}, nil
+	}
+
+	return parseRegoResult(rs[0].Expressions[0].Value)
+}
+
+// parseRegoResult - maps the value of the decision onto a regoDecision, the value is either
+//   - a bool, true when the request is allowed
+//   - a set or array of deny messages
+//   - an object with the optional fields allowed, deny and warnings, allowed defaults to true
+//     when there are no deny messages
+func parseRegoResult(value interface{}) (regoDecision, error) {
+
+	switch v := value.(type) {
+
+	case bool:
+		return regoDecision{Allowed: v}, nil
+
+	case []interface{}:
+		deny, err := regoMessages(v)
+		if err != nil {
+			return regoDecision{}, err
+		}
+		return regoDecision{Allowed: len(deny) == 0, Deny: deny}, nil
+
+	case map[string]interface{}:
+		var (
+			decision regoDecision
+			err      error
+		)
+
+		if deny, ok := v["deny"].([]interface{}); ok {
+			if decision.Deny, err = regoMessages(deny); err != nil {
+				return regoDecision{}, err
+			}
+		}
+
+		if warnings, ok := v["warnings"].([]interface{}); ok {
+			if decision.Warnings, err = regoMessages(warnings); err != nil {
+				return regoDecision{}, err
+			}
+		}
+
+		decision.Allowed = len(decision.Deny) == 0
+		if allowed, ok := v["allowed"]; ok {
+			b, ok := allowed.(bool)
+			if !ok {
+				return regoDecision{}, fmt.Errorf("field allowed of the Rego decision must be a bool, got %T", allowed)
+			}
+			decision.Allowed = b && len(decision.Deny) == 0
+		}
+
+		return decision, nil
+
+	default:
+		return regoDecision{}, fmt.Errorf("unsupported Rego decision of type %T", value)
+	}
+}
+
+// regoMessages - converts a set or array of strings, or of objects with a msg field, into messages
+func regoMessages(values []interface{}) ([]string, error) {
+
+	msgs := make([]string, 0, len(values))
+
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			msgs = append(msgs, v)
+		case map[string]interface{}:
+			msg, ok := v["msg"].(string)
+			if !ok {
+				return nil, fmt.Errorf("Rego message object must have a string field msg")
+			}
+			msgs = append(msgs, msg)
+		default:
+			return nil, fmt.Errorf("unsupported Rego message of type %T", value)
+		}
+	}
+
+	return msgs, nil
+}
+
+// evaluateRego - evaluates the Rego policies and returns the deny messages as violations and the warnings,
+// a policy that fails to evaluate denies the request
+func (a *application) evaluateRego(ctx context.Context, review admissionv1.AdmissionReview) (webhook.Violations, []string) {
+
+	if a.rego == nil {
+		return nil, nil
+	}
+
+	decision, err := a.rego.evaluate(ctx, review)
+	if err != nil {
+		webhook.Logf(ctx, a.errorLog, "error evaluating the Rego policies on %v %v/%v - %v", review.Request.Kind.Kind,
+			review.Request.Namespace, review.Request.Name, err)
+		return webhook.Violations{{Rule: "rego", Message: "Denied because the Rego policies could not be evaluated - " + err.Error()}}, nil
+	}
+
+	var violations webhook.Violations
+	for _, msg := range decision.Deny {
+		violations = append(violations, webhook.Violation{Rule: "rego", Message: msg})
+	}
+
+	if !decision.Allowed && len(violations) == 0 {
+		violations = append(violations, webhook.Violation{Rule: "rego", Message: "Denied by the Rego policies"})
+	}
+
+	return violations, decision.Warnings
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+const testRegoPolicy = `
+package kubernetes.admission
+
+import rego.v1
+
+decision := {"allowed": count(deny) == 0, "deny": deny, "warnings": warnings}
+
+deny contains msg if {
+	input.request.kind.kind == "Pod"
+	not input.request.object.metadata.labels.owner
+	msg := "rego: the owner label is required"
+}
+
+warnings contains msg if {
+	input.request.kind.kind == "Pod"
+	not input.request.object.metadata.labels["cost-center"]
+	msg := "rego: the cost-center label will be required soon"
+}
+`
+
+func TestParseRegoResult(t *testing.T) {
+
+	tt := []struct {
+		name    string
+		value   interface{}
+		want    regoDecision
+		wantErr bool
+	}{
+		{name: "bool", value: false, want: regoDecision{Allowed: false}},
+		{name: "set of messages", value: []interface{}{"a", map[string]interface{}{"msg": "b"}}, want: regoDecision{Deny: []string{"a", "b"}}},
+		{name: "empty set", value: []interface{}{}, want: regoDecision{Allowed: true, Deny: []string{}}},
+		{
+			name:  "object with warnings only",
+			value: map[string]interface{}{"warnings": []interface{}{"w"}},
+			want:  regoDecision{Allowed: true, Warnings: []string{"w"}},
+		},
+		{
+			name:  "object that is not allowed without messages",
+			value: map[string]interface{}{"allowed": false},
+			want:  regoDecision{Allowed: false},
+		},
+		{name: "allowed is not a bool", value: map[string]interface{}{"allowed": "yes"}, wantErr: true},
+		{name: "unsupported value", value: "denied", wantErr: true},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+
+			got, err := parseRegoResult(tc.value)
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseRegoResult() error = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if err == nil && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseRegoResult() got=%+v, want=%+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateRego(t *testing.T) {
+
+	dir := t.TempDir()
+	policyFile := filepath.Join(dir, "policy.rego")
+
+	if err := os.WriteFile(policyFile, []byte(testRegoPolicy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	policy, err := NewRegoPolicy(ctx, dir, "kubernetes/admission/decision")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		errorLog: log.New(io.Discard, "", log.Ldate),
+		infoLog:  log.New(io.Discard, "", log.Ldate),
+		cfg:      &envConfig{},
+		rego:     policy,
+	}
+
+	data, err := os.ReadFile("test-files/admission-request-missing-labels.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(data, &review); err != nil {
+		t.Fatal(err)
+	}
+
+	violations, warnings := app.evaluateRego(context.Background(), review)
+
+	if got, want := violations.Messages(), "rego: the owner label is required"; got != want {
+		t.Errorf("evaluateRego() violations - got=%q, want=%q", got, want)
+	}
+
+	if want := []string{"rego: the cost-center label will be required soon"}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("evaluateRego() warnings - got=%v, want=%v", warnings, want)
+	}
+
+	// a policy that does not compile must not replace the loaded policy
+	if err := os.WriteFile(policyFile, []byte("package kubernetes.admission\n\ndeny contains"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := policy.reload(ctx); err == nil {
+		t.Error("reload() of an invalid policy did not return an error")
+	}
+
+	if violations, _ := app.evaluateRego(context.Background(), review); len(violations) != 1 {
+		t.Errorf("invalid policy replaced the loaded policy, got violations %v", violations.Rules())
+	}
+
+	// the policy is reloaded without a restart
+	allowAll := "package kubernetes.admission\n\ndecision := {\"allowed\": true}\n"
+	if err := os.WriteFile(policyFile, []byte(allowAll), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// make sure the modification time changes on file systems with a coarse resolution
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(policyFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := policy.reload(ctx)
+	if err != nil || !reloaded {
+		t.Fatalf("reload() got reloaded=%v err=%v, want a reloaded policy", reloaded, err)
+	}
+
+	if violations, _ := app.evaluateRego(context.Background(), review); len(violations) != 0 {
+		t.Errorf("reloaded policy still denies the request - %v", violations.Messages())
+	}
+}
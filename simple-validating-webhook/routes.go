@@ -2,13 +2,71 @@ package main
 
 import (
 	chi "github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func (app *application) setupRoutes() chi.Router {
 	
 	router := chi.NewRouter()
+	router.Use(app.requestID, app.accessLog, app.recoverPanics, app.limitBody)
 	router.Get("/healthcheck", app.healthcheck)
-	router.Post("/validate", app.validate)
-	router.Get("/healthz", app.healthcheck)
+	if app.inflight == nil {
+		app.inflight = newInflightTracker()
+	}
+	// the admission endpoints and the APIs are only served to the authenticated callers, the probes are not
+	router.Group(func(router chi.Router) {
+		router.Use(traceRequests, app.requireClient)
+		if len(app.endpoints) == 0 {
+			server := app.newWebhookServer(exemptions{}, allValidators...)
+			server.SetTracker(app.inflight)
+			router.Method("POST", "/validate", server)
+		}
+		for _, ep := range app.endpoints {
+			server := ep.server()
+			server.SetTracker(app.inflight)
+			router.Method("POST", ep.path, server)
+		}
+		// without client authentication the decision history is only served on the admin port
+		if app.decisions != nil && app.auth != nil {
+			router.Get("/api/decisions", app.listDecisions)
+		}
+		if app.scanner != nil {
+			router.Get("/api/scan", app.scanReportHandler)
+		}
+	})
+	app.healthRoutes(router)
+	// without the admin port the metrics are scraped over TLS
+	if app.cfg.AdminPort == 0 {
+		router.Handle("/metrics", promhttp.Handler())
+	}
 	return router
 }
+
+// setupAdminRoutes - routes of the plaintext admin port, the health checks, the metrics, pprof with ENABLE_PPROF and
+// the decision history when the callers of the TLS port are not authenticated, the admission endpoints are only served
+// on the TLS port
+func (app *application) setupAdminRoutes() chi.Router {
+
+	router := chi.NewRouter()
+	router.Get("/healthcheck", app.healthcheck)
+	app.healthRoutes(router)
+	router.Handle("/metrics", promhttp.Handler())
+	if app.cfg.EnablePprof {
+		router.Mount("/debug", middleware.Profiler())
+	}
+	if app.decisions != nil && app.auth == nil {
+		router.Get("/api/decisions", app.listDecisions)
+	}
+	return router
+}
+
+// healthRoutes - adds the health checks served on both ports
+func (app *application) healthRoutes(router chi.Router) {
+
+	router.Get("/healthz", app.healthcheck)
+	router.Get("/readyz", app.healthHandler("readyz", app.readyChecks))
+	router.Get("/readyz/{check}", app.healthHandler("readyz", app.readyChecks))
+	router.Get("/livez", app.healthHandler("livez", app.liveChecks))
+	router.Get("/livez/{check}", app.healthHandler("livez", app.liveChecks))
+}
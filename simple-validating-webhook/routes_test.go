@@ -33,6 +33,11 @@ func TestHTTPRoutesUsingCorrectHTTPMethods(t *testing.T) {
 			path:           "/healthz",
 			wantStatusCode: http.StatusOK,
 		},
+		{
+			name:           "test /metrics with GET method",
+			path:           "/metrics",
+			wantStatusCode: http.StatusOK,
+		},
 		{
 			name:           "test /thisdoesnotexist with GET method",
 			path:           "/thisdoesnotexist",
@@ -192,3 +197,61 @@ func TestHTTPRoutePOSTMethod(t *testing.T) {
 	}
 
 }
+
+// TestAdminRoutes - with an admin port the metrics and pprof are only served by the admin router, pprof only
+// with ENABLE_PPROF, the admission endpoints only by the webhook router and the health checks by both
+func TestAdminRoutes(t *testing.T) {
+
+	app := &application{
+		errorLog: log.New(io.Discard, "", log.Ldate),
+		infoLog:  log.New(io.Discard, "", log.Ldate),
+		cfg:      &envConfig{AdminPort: 8081, EnablePprof: true},
+	}
+
+	admin := httptest.NewServer(app.setupAdminRoutes())
+	defer admin.Close()
+
+	withoutPprof := &application{errorLog: app.errorLog, infoLog: app.infoLog, cfg: &envConfig{AdminPort: 8081}}
+	adminWithoutPprof := httptest.NewServer(withoutPprof.setupAdminRoutes())
+	defer adminWithoutPprof.Close()
+
+	webhook := httptest.NewServer(app.setupRoutes())
+	defer webhook.Close()
+
+	tt := []struct {
+		name           string
+		url            string
+		method         string
+		wantStatusCode int
+	}{
+		{name: "admin healthz", url: admin.URL + "/healthz", method: http.MethodGet, wantStatusCode: http.StatusOK},
+		{name: "admin livez", url: admin.URL + "/livez", method: http.MethodGet, wantStatusCode: http.StatusOK},
+		{name: "admin metrics", url: admin.URL + "/metrics", method: http.MethodGet, wantStatusCode: http.StatusOK},
+		{name: "admin pprof", url: admin.URL + "/debug/pprof/", method: http.MethodGet, wantStatusCode: http.StatusOK},
+		{name: "admin pprof is off by default", url: adminWithoutPprof.URL + "/debug/pprof/", method: http.MethodGet, wantStatusCode: http.StatusNotFound},
+		{name: "admin does not serve validate", url: admin.URL + "/validate", method: http.MethodPost, wantStatusCode: http.StatusNotFound},
+		{name: "webhook livez", url: webhook.URL + "/livez", method: http.MethodGet, wantStatusCode: http.StatusOK},
+		{name: "webhook does not serve metrics", url: webhook.URL + "/metrics", method: http.MethodGet, wantStatusCode: http.StatusNotFound},
+		{name: "webhook does not serve pprof", url: webhook.URL + "/debug/pprof/", method: http.MethodGet, wantStatusCode: http.StatusNotFound},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			req, err := http.NewRequest(tc.method, tc.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != tc.wantStatusCode {
+				t.Errorf("HTTP status code - want=%v got=%v", tc.wantStatusCode, res.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"simple-validating-webhook/webhook"
+)
+
+// requiredLabel is a label that has to be present on an object with a non-empty value
+// and, when pattern is set, with a value matching the pattern
+type requiredLabel struct {
+	key     string
+	pattern *regexp.Regexp
+}
+
+// ParseRequiredLabels - parses the value of the required labels namespace annotation, which is either
+// a comma separated list of "key" or "key=regex" entries, e.g. "owner,cost-center=^cc-[0-9]+$",
+// or a JSON object mapping the label key to a regex (an empty regex only requires the label),
+// e.g. {"owner": "", "data-class": "^(public|internal|restricted)$"}
+func ParseRequiredLabels(value string) ([]requiredLabel, error) {
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	entries := map[string]string{}
+
+	if strings.HasPrefix(value, "{") {
+		if err := json.Unmarshal([]byte(value), &entries); err != nil {
+			return nil, fmt.Errorf("invalid JSON - %v", err)
+		}
+	} else {
+		for _, entry := range strings.Split(value, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			key, pattern := entry, ""
+			if i := strings.Index(entry, "="); i >= 0 {
+				key, pattern = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+			}
+			entries[key] = pattern
+		}
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		if key == "" {
+			return nil, fmt.Errorf("empty label key")
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	required := make([]requiredLabel, 0, len(keys))
+	for _, key := range keys {
+		req := requiredLabel{key: key}
+		if pattern := entries[key]; pattern != "" {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex for label %v - %v", key, err)
+			}
+			req.pattern = re
+		}
+		required = append(required, req)
+	}
+
+	return required, nil
+}
+
+// requiredLabels - merges the global label a.cfg.Label with the labels declared in the required labels
+// annotation of the namespace, a namespace can add labels and add a regex to the global label
+func (a *application) requiredLabels(ns *corev1.Namespace) ([]requiredLabel, error) {
+
+	required := []requiredLabel{{key: a.cfg.Label}}
+
+	if a.cfg.RequiredLabelsAnnotation == "" {
+		return required, nil
+	}
+
+	nsRequired, err := ParseRequiredLabels(ns.GetAnnotations()[a.cfg.RequiredLabelsAnnotation])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, req := range nsRequired {
+		if req.key == a.cfg.Label {
+			required[0].pattern = req.pattern
+			continue
+		}
+		required = append(required, req)
+	}
+
+	return required, nil
+}
+
+// checkPodLabels - checks that the Pod has the global label a.cfg.Label and the labels required by the namespace,
+// returns the keys of the checked labels and the failed rules
+func (a *application) checkPodLabels(pod *corev1.Pod, ns *corev1.Namespace) ([]string, webhook.Violations) {
+
+	required, err := a.requiredLabels(ns)
+	if err != nil {
+		msg := fmt.Sprintf("Denied because the annotation %v on the namespace %v is invalid - %v",
+			a.cfg.RequiredLabelsAnnotation, ns.Name, err)
+		return nil, webhook.Violations{{Rule: ruleInvalidRequiredLabels, Message: msg}}
+	}
+
+	var (
+		checked    []string
+		violations webhook.Violations
+	)
+
+	for _, req := range required {
+
+		checked = append(checked, req.key)
+
+		data := messageData{
+			Kind:      "Pod",
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Label:     req.key,
+			Contact:   a.remediationContact(ns, pod.Labels[a.cfg.Label]),
+		}
+
+		val, ok := pod.ObjectMeta.Labels[req.key]
+		data.Value = val
+
+		rule := ""
+		switch {
+		case !ok:
+			rule = ruleMissingLabel
+		case val == "": // check if the value of the label is not empty
+			rule = ruleEmptyLabel
+		case req.pattern != nil && !req.pattern.MatchString(val):
+			rule = ruleLabelMismatch
+			data.Pattern = req.pattern.String()
+		case req.key == a.cfg.Label && !a.knownTeam(val):
+			rule = ruleUnknownTeam
+			data.Suggestion = a.teams.suggest(val)
+		default:
+			continue
+		}
+
+		violations = append(violations, webhook.Violation{
+			Rule:    rule,
+			Field:   req.key,
+			Value:   val,
+			Message: a.denialMessage(rule, data),
+		})
+	}
+
+	return checked, violations
+}
+
+// knownTeam - returns true if the value is a registered team name or alias, every value is
+// accepted when no team registry is configured or it has not been loaded yet
+func (a *application) knownTeam(value string) bool {
+
+	if a.teams == nil || !a.teams.isLoaded() {
+		return true
+	}
+
+	_, ok := a.teams.lookup(value)
+	return ok
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseRequiredLabels(t *testing.T) {
+
+	tt := []struct {
+		name     string
+		value    string
+		wantKeys []string
+		wantErr  bool
+	}{
+		{name: "empty annotation", value: "", wantKeys: []string{}},
+		{name: "comma separated keys", value: "owner, cost-center ,data-class", wantKeys: []string{"cost-center", "data-class", "owner"}},
+		{name: "comma separated keys with regex", value: "owner,cost-center=^cc-[0-9]+$", wantKeys: []string{"cost-center", "owner"}},
+		{name: "JSON object", value: `{"owner": "", "data-class": "^(public|internal)$"}`, wantKeys: []string{"data-class", "owner"}},
+		{name: "invalid regex", value: "cost-center=^cc-[0-9+$", wantErr: true},
+		{name: "invalid JSON", value: `{"owner": `, wantErr: true},
+		{name: "empty key", value: "=^cc-$", wantErr: true},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+
+			got, err := ParseRequiredLabels(tc.value)
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseRequiredLabels() error = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			keys := []string{}
+			for _, req := range got {
+				keys = append(keys, req.key)
+			}
+
+			if !reflect.DeepEqual(keys, tc.wantKeys) {
+				t.Errorf("ParseRequiredLabels() keys - got=%v, want=%v", keys, tc.wantKeys)
+			}
+		})
+	}
+}
+
+func TestCheckPodLabels(t *testing.T) {
+
+	tt := []struct {
+		name          string
+		nsAnnotations map[string]string
+		podLabels     map[string]string
+		wantRules     []string
+	}{
+		{
+			name:      "global label only",
+			podLabels: map[string]string{"owner": "team-a"},
+			wantRules: []string{},
+		},
+		{
+			name:      "global label missing",
+			podLabels: map[string]string{"app": "busybox"},
+			wantRules: []string{"missing-label/owner"},
+		},
+		{
+			name:          "namespace requires additional labels",
+			nsAnnotations: map[string]string{"example.com/required-labels": "cost-center=^cc-[0-9]+$,data-class"},
+			podLabels:     map[string]string{"owner": "team-a", "cost-center": "marketing", "data-class": ""},
+			wantRules:     []string{"label-mismatch/cost-center", "empty-label/data-class"},
+		},
+		{
+			name:          "namespace adds a regex to the global label",
+			nsAnnotations: map[string]string{"example.com/required-labels": "owner=^team-"},
+			podLabels:     map[string]string{"owner": "platform"},
+			wantRules:     []string{"label-mismatch/owner"},
+		},
+		{
+			name:          "all required labels are present",
+			nsAnnotations: map[string]string{"example.com/required-labels": `{"cost-center": "^cc-[0-9]+$"}`},
+			podLabels:     map[string]string{"owner": "team-a", "cost-center": "cc-42"},
+			wantRules:     []string{},
+		},
+		{
+			name:          "invalid namespace annotation",
+			nsAnnotations: map[string]string{"example.com/required-labels": "cost-center=("},
+			podLabels:     map[string]string{"owner": "team-a"},
+			wantRules:     []string{"invalid-required-labels/"},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+
+			app := &application{
+				errorLog: log.New(io.Discard, "", log.Ldate),
+				infoLog:  log.New(io.Discard, "", log.Ldate),
+				cfg: &envConfig{
+					Label:                    "owner",
+					RequiredLabelsAnnotation: "example.com/required-labels",
+				},
+			}
+
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook-demo", Annotations: tc.nsAnnotations},
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "busybox1", Namespace: "webhook-demo", Labels: tc.podLabels},
+			}
+
+			_, violations := app.checkPodLabels(pod, ns)
+
+			if got := violations.Rules(); !reflect.DeepEqual(got, tc.wantRules) {
+				t.Errorf("checkPodLabels() failed rules - got=%v, want=%v", got, tc.wantRules)
+			}
+		})
+	}
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"simple-validating-webhook/webhook"
+)
+
+// scanPageSize - objects requested from the API server per list call
+const scanPageSize = 500
+
+// scanObject is an existing Pod or workload checked by the scanner
+type scanObject struct {
+	gvk      schema.GroupVersionKind
+	meta     metav1.ObjectMeta
+	object   runtime.Object          // sent as the object of the admission request
+	template *corev1.PodTemplateSpec // pod template of a workload, nil for a Pod
+}
+
+// scanKind lists the objects of a kind, one page per call
+type scanKind struct {
+	gvk  schema.GroupVersionKind
+	list func(ctx context.Context, client kubernetes.Interface, opts metav1.ListOptions) ([]scanObject, string, error)
+}
+
+// scanKinds - the kinds checked by the scanner, objects controlled by another object, e.g. the Pods of a
+// ReplicaSet or the ReplicaSets of a Deployment, are skipped as the pod template of the controller is checked
+var scanKinds = []scanKind{
+	{
+		gvk: corev1.SchemeGroupVersion.WithKind("Pod"),
+		list: func(ctx context.Context, client kubernetes.Interface, opts metav1.ListOptions) ([]scanObject, string, error) {
+			list, err := client.CoreV1().Pods("").List(ctx, opts)
+			if err != nil {
+				return nil, "", err
+			}
+			objects := make([]scanObject, 0, len(list.Items))
+			for i := range list.Items {
+				item := &list.Items[i]
+				objects = append(objects, scanObject{meta: item.ObjectMeta, object: item})
+			}
+			return objects, list.Continue, nil
+		},
+	},
+	{
+		gvk: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		list: func(ctx context.Context, client kubernetes.Interface, opts metav1.ListOptions) ([]scanObject, string, error) {
+			list, err := client.AppsV1().Deployments("").List(ctx, opts)
+			if err != nil {
+				return nil, "", err
+			}
+			objects := make([]scanObject, 0, len(list.Items))
+			for i := range list.Items {
+				item := &list.Items[i]
+				objects = append(objects, scanObject{meta: item.ObjectMeta, object: item, template: &item.Spec.Template})
+			}
+			return objects, list.Continue, nil
+		},
+	},
+	{
+		gvk: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+		list: func(ctx context.Context, client kubernetes.Interface, opts metav1.ListOptions) ([]scanObject, string, error) {
+			list, err := client.AppsV1().StatefulSets("").List(ctx, opts)
+			if err != nil {
+				return nil, "", err
+			}
+			objects := make([]scanObject, 0, len(list.Items))
+			for i := range list.Items {
+				item := &list.Items[i]
+				objects = append(objects, scanObject{meta: item.ObjectMeta, object: item, template: &item.Spec.Template})
+			}
+			return objects, list.Continue, nil
+		},
+	},
+	{
+		gvk: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+		list: func(ctx context.Context, client kubernetes.Interface, opts metav1.ListOptions) ([]scanObject, string, error) {
+			list, err := client.AppsV1().DaemonSets("").List(ctx, opts)
+			if err != nil {
+				return nil, "", err
+			}
+			objects := make([]scanObject, 0, len(list.Items))
+			for i := range list.Items {
+				item := &list.Items[i]
+				objects = append(objects, scanObject{meta: item.ObjectMeta, object: item, template: &item.Spec.Template})
+			}
+			return objects, list.Continue, nil
+		},
+	},
+	{
+		gvk: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"},
+		list: func(ctx context.Context, client kubernetes.Interface, opts metav1.ListOptions) ([]scanObject, string, error) {
+			list, err := client.AppsV1().ReplicaSets("").List(ctx, opts)
+			if err != nil {
+				return nil, "", err
+			}
+			objects := make([]scanObject, 0, len(list.Items))
+			for i := range list.Items {
+				item := &list.Items[i]
+				objects = append(objects, scanObject{meta: item.ObjectMeta, object: item, template: &item.Spec.Template})
+			}
+			return objects, list.Continue, nil
+		},
+	},
+	{
+		gvk: schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"},
+		list: func(ctx context.Context, client kubernetes.Interface, opts metav1.ListOptions) ([]scanObject, string, error) {
+			list, err := client.BatchV1().Jobs("").List(ctx, opts)
+			if err != nil {
+				return nil, "", err
+			}
+			objects := make([]scanObject, 0, len(list.Items))
+			for i := range list.Items {
+				item := &list.Items[i]
+				objects = append(objects, scanObject{meta: item.ObjectMeta, object: item, template: &item.Spec.Template})
+			}
+			return objects, list.Continue, nil
+		},
+	},
+	{
+		gvk: schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "CronJob"},
+		list: func(ctx context.Context, client kubernetes.Interface, opts metav1.ListOptions) ([]scanObject, string, error) {
+			list, err := client.BatchV1().CronJobs("").List(ctx, opts)
+			if err != nil {
+				return nil, "", err
+			}
+			objects := make([]scanObject, 0, len(list.Items))
+			for i := range list.Items {
+				item := &list.Items[i]
+				objects = append(objects, scanObject{meta: item.ObjectMeta, object: item, template: &item.Spec.JobTemplate.Spec.Template})
+			}
+			return objects, list.Continue, nil
+		},
+	},
+}
+
+// scanFinding is an existing object that violates the rules
+type scanFinding struct {
+	Namespace  string             `json:"namespace"`
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Name       string             `json:"name"`
+	UID        types.UID          `json:"uid,omitempty"`
+	Violations webhook.Violations `json:"violations"`
+}
+
+// scanReport is the outcome of a scan of the cluster
+type scanReport struct {
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Objects  int           `json:"objects"` // Pods and workloads checked
+	Errors   int           `json:"errors"`  // objects that could not be checked
+	Findings []scanFinding `json:"findings"`
+}
+
+// scanner periodically checks the existing Pods and workloads with the validators of the webhook, it
+// finds the objects created before the validation was enforced in their namespace
+type scanner struct {
+	app      *application
+	servers  []*webhook.Server // the default server or the servers of the endpoints
+	interval time.Duration
+
+	mu     sync.RWMutex
+	report *scanReport // nil until the first scan completed
+}
+
+// newScanner - returns a scanner with the validators of the endpoints, or of /validate when there are
+// no endpoints
+func (app *application) newScanner(interval time.Duration) *scanner {
+
+	s := &scanner{app: app, interval: interval}
+
+	if len(app.endpoints) == 0 {
+		s.servers = append(s.servers, app.newWebhookServer(exemptions{}, allValidators...))
+	}
+	for _, ep := range app.endpoints {
+		s.servers = append(s.servers, ep.server())
+	}
+
+	return s
+}
+
+// run - scans the cluster at start and then every interval until the context is cancelled
+func (s *scanner) run(ctx context.Context) {
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.scan(ctx); err != nil {
+			scanErrors.Inc()
+			s.app.errorLog.Printf("error scanning the existing workloads - %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan - checks every Pod and workload, publishes the report and the metrics and returns the report
+func (s *scanner) scan(ctx context.Context) (*scanReport, error) {
+
+	report := &scanReport{Started: time.Now().UTC(), Findings: []scanFinding{}}
+
+	// the log lines of a scan, including the ones of the validators, carry the ID of the scan
+	ctx = webhook.WithRequestID(ctx, "scan-"+report.Started.Format("20060102T150405Z"))
+
+	// the namespaces are listed once instead of fetched for every object
+	list, err := s.app.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing the namespaces - %v", err)
+	}
+	namespaces := make(map[string]*corev1.Namespace, len(list.Items))
+	for i := range list.Items {
+		namespaces[list.Items[i].Name] = &list.Items[i]
+	}
+	getNamespace := func(ctx context.Context, name string) (*corev1.Namespace, error) {
+		if ns, ok := namespaces[name]; ok {
+			return ns, nil
+		}
+		return nil, fmt.Errorf("namespace %v not found", name)
+	}
+
+	for _, kind := range scanKinds {
+
+		opts := metav1.ListOptions{Limit: scanPageSize}
+		for {
+			objects, next, err := kind.list(ctx, s.app.client, opts)
+			if err != nil {
+				return nil, fmt.Errorf("error listing the %v objects - %v", kind.gvk.Kind, err)
+			}
+
+			for _, obj := range objects {
+
+				if metav1.GetControllerOf(&obj.meta) != nil {
+					continue
+				}
+				obj.gvk = kind.gvk
+				report.Objects++
+
+				violations, err := s.check(ctx, obj, getNamespace)
+				if err != nil {
+					report.Errors++
+					scanErrors.Inc()
+					webhook.Logf(ctx, s.app.errorLog, "error scanning %v %v/%v - %v", kind.gvk.Kind, obj.meta.Namespace, obj.meta.Name, err)
+					continue
+				}
+
+				if len(violations) > 0 {
+					webhook.Logf(ctx, s.app.infoLog, "Scan found %v %v/%v violating %v", kind.gvk.Kind, obj.meta.Namespace, obj.meta.Name, violations.Rules())
+					report.Findings = append(report.Findings, scanFinding{
+						Namespace:  obj.meta.Namespace,
+						APIVersion: kind.gvk.GroupVersion().String(),
+						Kind:       kind.gvk.Kind,
+						Name:       obj.meta.Name,
+						UID:        obj.meta.UID,
+						Violations: violations,
+					})
+				}
+			}
+
+			if next == "" {
+				break
+			}
+			opts.Continue = next
+		}
+	}
+
+	report.Finished = time.Now().UTC()
+	s.publish(report)
+
+	webhook.Logf(ctx, s.app.infoLog, "Scanned %d objects in %v, %d violate the rules", report.Objects,
+		report.Finished.Sub(report.Started).Round(time.Millisecond), len(report.Findings))
+
+	return report, nil
+}
+
+// check - runs the validators on the object and, for a workload, on a Pod made from its pod template,
+// the violations found by several servers are reported once
+func (s *scanner) check(ctx context.Context, obj scanObject, namespaces webhook.NamespaceGetter) (webhook.Violations, error) {
+
+	requests := make([]*webhook.Request, 0, 2)
+
+	req, err := scanRequest(obj.gvk, obj.meta, obj.object, namespaces)
+	if err != nil {
+		return nil, err
+	}
+	requests = append(requests, req)
+
+	if obj.template != nil {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        obj.meta.Name,
+				Namespace:   obj.meta.Namespace,
+				Labels:      obj.template.Labels,
+				Annotations: obj.template.Annotations,
+			},
+			Spec: obj.template.Spec,
+		}
+		req, err := scanRequest(corev1.SchemeGroupVersion.WithKind("Pod"), pod.ObjectMeta, pod, namespaces)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+
+	var violations webhook.Violations
+	seen := map[webhook.Violation]bool{}
+
+	for _, server := range s.servers {
+		for _, req := range requests {
+			result, _, err := server.Evaluate(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range result.Violations {
+				if !seen[v] {
+					seen[v] = true
+					violations = append(violations, v)
+				}
+			}
+		}
+	}
+
+	return violations, nil
+}
+
+// scanRequest - returns a dry-run CREATE request of the object, as if it was created again
+func scanRequest(gvk schema.GroupVersionKind, meta metav1.ObjectMeta, obj runtime.Object, namespaces webhook.NamespaceGetter) (*webhook.Request, error) {
+
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal the %v object - %v", gvk.Kind, err)
+	}
+
+	dryRun := true
+	kind := metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind}
+
+	review := &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:         meta.UID,
+			Kind:        kind,
+			RequestKind: &kind,
+			Name:        meta.Name,
+			Namespace:   meta.Namespace,
+			Operation:   admissionv1.Create,
+			Object:      runtime.RawExtension{Raw: raw},
+			DryRun:      &dryRun,
+		},
+	}
+
+	return webhook.NewRequest(review, namespaces), nil
+}
+
+// publish - keeps the report for /api/scan and updates the metrics
+func (s *scanner) publish(report *scanReport) {
+
+	s.mu.Lock()
+	s.report = report
+	s.mu.Unlock()
+
+	scanViolations.Reset()
+	for _, f := range report.Findings {
+		for _, v := range f.Violations {
+			scanViolations.WithLabelValues(f.Namespace, f.Kind, v.Rule).Inc()
+		}
+	}
+
+	scanObjects.Set(float64(report.Objects))
+	scanDuration.Set(report.Finished.Sub(report.Started).Seconds())
+	scanLastSuccess.Set(float64(report.Finished.Unix()))
+
+	if s.app.reports != nil {
+		s.app.reports.recordScan(report)
+	}
+}
+
+// lastReport - returns the report of the last completed scan, nil before the first scan completed
+func (s *scanner) lastReport() *scanReport {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.report
+}
+
+// scanReportHandler - returns the report of the last scan, optionally only the findings of a namespace, e.g.
+// GET /api/scan?namespace=team-a
+func (app *application) scanReportHandler(w http.ResponseWriter, r *http.Request) {
+
+	report := app.scanner.lastReport()
+	if report == nil {
+		msg := "No scan has completed yet"
+		if app.cfg.LeaderElection {
+			msg += " on this replica, the scan runs on the leader"
+		}
+		app.writeErrorMessage(w, msg, http.StatusServiceUnavailable)
+		return
+	}
+
+	if namespace := r.URL.Query().Get("namespace"); namespace != "" {
+		filtered := *report
+		filtered.Findings = []scanFinding{}
+		for _, f := range report.Findings {
+			if f.Namespace == namespace {
+				filtered.Findings = append(filtered.Findings, f)
+			}
+		}
+		report = &filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newScanTestApp - returns an application with the enforced namespace team-a and the namespace legacy
+// where the validation is not enforced, both with Pods and Deployments with and without the owner label
+func newScanTestApp(t *testing.T) *application {
+
+	controller := true
+	objects := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Annotations: map[string]string{"example.com/validate": "true"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "legacy"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bare", Namespace: "team-a"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "labelled", Namespace: "team-a", Labels: map[string]string{"owner": "team-a"}}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-5d8f7-abcde", Namespace: "team-a", OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d8f7", Controller: &controller},
+		}}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "legacy"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team-a"}},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "team-a"},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"owner": "team-a"}},
+			}},
+		},
+	}
+
+	return &application{
+		errorLog: log.New(io.Discard, "", log.Ldate),
+		infoLog:  log.New(io.Discard, "", log.Ldate),
+		cfg:      &envConfig{Annotation: "example.com/validate", Label: "owner"},
+		client:   fake.NewSimpleClientset(objects...),
+	}
+}
+
+func TestScan(t *testing.T) {
+
+	app := newScanTestApp(t)
+	s := app.newScanner(0)
+
+	report, err := s.scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the Pod controlled by a ReplicaSet is skipped
+	if report.Objects != 5 || report.Errors != 0 {
+		t.Errorf("scanned objects - got=%v errors=%v, want=5 errors=0", report.Objects, report.Errors)
+	}
+
+	var got []string
+	for _, f := range report.Findings {
+		got = append(got, f.Namespace+"/"+f.Kind+"/"+f.Name)
+		if len(f.Violations) == 0 || f.Violations[0].Rule != ruleMissingLabel {
+			t.Errorf("finding %v - unexpected violations %+v", f.Name, f.Violations)
+		}
+	}
+	sort.Strings(got)
+
+	want := []string{"team-a/Deployment/web", "team-a/Pod/bare"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("findings - got=%v, want=%v", got, want)
+	}
+
+	if v := testutil.ToFloat64(scanViolations.WithLabelValues("team-a", "Deployment", ruleMissingLabel)); v != 1 {
+		t.Errorf("webhook_scan_violations - got=%v, want=1", v)
+	}
+	if v := testutil.ToFloat64(scanObjects); v != 5 {
+		t.Errorf("webhook_scan_objects - got=%v, want=5", v)
+	}
+}
+
+func TestScanReportHandler(t *testing.T) {
+
+	app := newScanTestApp(t)
+	app.scanner = app.newScanner(0)
+
+	srv := httptest.NewServer(app.setupRoutes())
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("report before the first scan - got=%v, want=%v", res.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	if _, err := app.scanner.scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name      string
+		namespace string
+		findings  int
+	}{
+		{name: "all namespaces", findings: 2},
+		{name: "enforced namespace", namespace: "team-a", findings: 2},
+		{name: "namespace without findings", namespace: "legacy", findings: 0},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			res, err := http.Get(srv.URL + "/api/scan?namespace=" + tc.namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("HTTP status code mismatch want=%v, got=%v", http.StatusOK, res.StatusCode)
+			}
+
+			var report scanReport
+			if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(report.Findings) != tc.findings {
+				t.Errorf("findings - got=%v, want=%v", len(report.Findings), tc.findings)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// shutdown - drains the servers: /readyz fails first so that the replica is removed from the endpoints
+// of the Service, the servers keep serving during SHUTDOWN_DELAY as the API server may still send
+// requests until the endpoints are updated, and then wait up to SHUTDOWN_TIMEOUT for the requests
+// in flight, the requests still running after the timeout are aborted
+func (app *application) shutdown(servers ...*http.Server) error {
+
+	app.draining.Store(true)
+
+	if app.cfg.ShutdownDelay > 0 {
+		app.infoLog.Printf("Failing readiness and serving for %v while the endpoints are updated", app.cfg.ShutdownDelay)
+		time.Sleep(app.cfg.ShutdownDelay)
+	}
+
+	app.infoLog.Printf("Draining the requests in flight for up to %v", app.cfg.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), app.cfg.ShutdownTimeout)
+	defer cancel()
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			if err := server.Shutdown(ctx); err != nil {
+				_ = server.Close()
+				errs <- fmt.Errorf("the requests in flight on %v after %v were aborted - %v", server.Addr, app.cfg.ShutdownTimeout, err)
+				return
+			}
+			errs <- nil
+		}(server)
+	}
+
+	var failed error
+	for range servers {
+		if err := <-errs; err != nil {
+			failed = err
+		}
+	}
+
+	return failed
+}
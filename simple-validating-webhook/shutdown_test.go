@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	chi "github.com/go-chi/chi/v5"
+)
+
+// newShutdownTestServer - serves /readyz and /slow, which answers once release is closed
+func newShutdownTestServer(t *testing.T, app *application, release chan struct{}) (*http.Server, string) {
+
+	router := chi.NewRouter()
+	router.Get("/readyz", app.healthHandler("readyz", app.readyChecks))
+	router.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_, _ = io.WriteString(w, "done")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{Handler: router}
+	go func() { _ = server.Serve(ln) }()
+
+	return server, "http://" + ln.Addr().String()
+}
+
+func newShutdownTestApp(delay, timeout time.Duration) *application {
+	return &application{
+		errorLog:    log.New(io.Discard, "", log.Ldate),
+		infoLog:     log.New(io.Discard, "", log.Ldate),
+		cfg:         &envConfig{ShutdownDelay: delay, ShutdownTimeout: timeout},
+		certificate: &x509.Certificate{NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)},
+		draining:    &atomic.Bool{},
+	}
+}
+
+// TestShutdownDrainsRequests - readiness fails during the delay while the requests in flight and new
+// requests are still served, the shutdown waits for the requests in flight
+func TestShutdownDrainsRequests(t *testing.T) {
+
+	app := newShutdownTestApp(300*time.Millisecond, 5*time.Second)
+	release := make(chan struct{})
+	server, url := newShutdownTestServer(t, app, release)
+
+	slow := make(chan error, 1)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		slow <- err
+	}()
+
+	// wait for the slow request to be in flight
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- app.shutdown(server) }()
+
+	// during the delay the server answers, but is not ready
+	time.Sleep(100 * time.Millisecond)
+	res, err := http.Get(url + "/readyz?verbose")
+	if err != nil {
+		t.Fatalf("request during the shutdown delay failed - %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusInternalServerError || !strings.Contains(string(body), "[-]shutdown failed") {
+		t.Errorf("readyz during the shutdown - got=%v %s", res.StatusCode, body)
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown returned with a request in flight - %v", err)
+	case <-time.After(400 * time.Millisecond):
+	}
+
+	close(release)
+
+	if err := <-slow; err != nil {
+		t.Errorf("request in flight failed - %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("shutdown() unexpected error - %v", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+
+	app := newShutdownTestApp(0, 100*time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+	server, url := newShutdownTestServer(t, app, release)
+
+	go func() {
+		if res, err := http.Get(url + "/slow"); err == nil {
+			res.Body.Close()
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := app.shutdown(server); err == nil {
+		t.Errorf("shutdown() returned no error with a request still in flight")
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+// team is an entry of the team registry
+type team struct {
+	Name     string   `json:"name"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Contacts []string `json:"contacts,omitempty"`
+}
+
+// teamRegistryFile is the format of the team registry file or ConfigMap key, e.g.
+//
+//	teams:
+//	  - name: platform
+//	    aliases: [platform-eng, infra]
+//	    contacts: ["#platform-oncall"]
+type teamRegistryFile struct {
+	Teams []team `json:"teams"`
+}
+
+// teamRegistry holds the registered teams, it is safe for concurrent use and is
+// updated in place when the source file or ConfigMap changes
+type teamRegistry struct {
+	mu     sync.RWMutex
+	loaded bool
+	teams  map[string]*team // lower-cased team names and aliases
+	names  []string         // sorted keys of teams, used for suggestions
+	raw    []byte           // last loaded content, used to skip reloading unchanged content
+}
+
+// NewTeamRegistry - returns an empty registry, use update or one of the watch functions to load teams
+func NewTeamRegistry() *teamRegistry {
+	return &teamRegistry{teams: map[string]*team{}}
+}
+
+// update - parses the registry content and replaces the registered teams, the previous teams are
+// kept if the content is invalid
+func (r *teamRegistry) update(data []byte) error {
+
+	var file teamRegistryFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("error parsing the team registry - %v", err)
+	}
+
+	teams := map[string]*team{}
+	for i := range file.Teams {
+		t := &file.Teams[i]
+		if strings.TrimSpace(t.Name) == "" {
+			return fmt.Errorf("error parsing the team registry - team %d has no name", i)
+		}
+		for _, name := range append([]string{t.Name}, t.Aliases...) {
+			key := strings.ToLower(strings.TrimSpace(name))
+			if other, ok := teams[key]; ok && other != t {
+				return fmt.Errorf("error parsing the team registry - %q is used by teams %v and %v", name, other.Name, t.Name)
+			}
+			teams[key] = t
+		}
+	}
+
+	names := make([]string, 0, len(teams))
+	for name := range teams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.teams, r.names, r.raw, r.loaded = teams, names, data, true
+
+	return nil
+}
+
+// changed - returns true if the content differs from the last loaded content
+func (r *teamRegistry) changed(data []byte) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !r.loaded || !bytes.Equal(r.raw, data)
+}
+
+// isLoaded - returns true once the registry has been loaded successfully
+func (r *teamRegistry) isLoaded() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.loaded
+}
+
+// lookup - returns the team registered with the name or alias, the match is case-insensitive
+func (r *teamRegistry) lookup(name string) (team, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.teams[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return team{}, false
+	}
+	return *t, true
+}
+
+// suggest - returns the registered name or alias closest to the value by edit distance, or an
+// empty string if no name is close enough to be a likely typo
+func (r *teamRegistry) suggest(value string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	// allow roughly one edit for every three characters, with a minimum of two edits
+	maxDistance := len(value) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+
+	best, bestDistance := "", maxDistance+1
+	for _, name := range r.names {
+		if d := editDistance(value, name); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+
+	return best
+}
+
+// editDistance - returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// minInt - returns the smallest of the values
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// watchFile - loads the registry from a local file and reloads it every interval until the context is
+// cancelled, this also picks up the updates of a ConfigMap mounted as a volume
+func (r *teamRegistry) watchFile(ctx context.Context, path string, interval time.Duration, infoLog, errorLog *log.Logger) {
+
+	load := func() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errorLog.Printf("error reading the team registry file %v - %v", path, err)
+			return
+		}
+		if !r.changed(data) {
+			return
+		}
+		if err := r.update(data); err != nil {
+			errorLog.Printf("%v, keeping the previous teams", err)
+			return
+		}
+		infoLog.Printf("Loaded the team registry from the file %v", path)
+	}
+
+	load()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			load()
+		}
+	}
+}
+
+// watchConfigMap - loads the registry from a key of a ConfigMap and reloads it on every change until the
+// context is cancelled, the ConfigMap reference is in the form namespace/name
+func (r *teamRegistry) watchConfigMap(ctx context.Context, client kubernetes.Interface, ref, key string, infoLog, errorLog *log.Logger) error {
+
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid team registry ConfigMap %q, must be namespace/name", ref)
+	}
+	namespace, name := parts[0], parts[1]
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = "metadata.name=" + name
+		}))
+
+	load := func(obj interface{}) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok || cm.Name != name {
+			return
+		}
+		data, ok := cm.Data[key]
+		if !ok {
+			errorLog.Printf("team registry ConfigMap %v has no key %v, keeping the previous teams", ref, key)
+			return
+		}
+		if !r.changed([]byte(data)) {
+			return
+		}
+		if err := r.update([]byte(data)); err != nil {
+			errorLog.Printf("%v, keeping the previous teams", err)
+			return
+		}
+		infoLog.Printf("Loaded the team registry from the ConfigMap %v", ref)
+	}
+
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    load,
+		UpdateFunc: func(_, obj interface{}) { load(obj) },
+		DeleteFunc: func(interface{}) {
+			errorLog.Printf("team registry ConfigMap %v was deleted, keeping the previous teams", ref)
+		},
+	})
+
+	factory.Start(ctx.Done())
+
+	return nil
+}
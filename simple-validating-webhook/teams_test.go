@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testTeamRegistry = `
+teams:
+  - name: platform
+    aliases: [infra]
+    contacts: ["#platform-oncall"]
+  - name: payments
+    contacts: ["#payments"]
+`
+
+func TestEditDistance(t *testing.T) {
+
+	tt := []struct {
+		a, b string
+		want int
+	}{
+		{a: "", b: "", want: 0},
+		{a: "platform", b: "platform", want: 0},
+		{a: "platfrom", b: "platform", want: 2},
+		{a: "paymnts", b: "payments", want: 1},
+		{a: "", b: "infra", want: 5},
+	}
+
+	for _, tc := range tt {
+		if got := editDistance(tc.a, tc.b); got != tc.want {
+			t.Errorf("editDistance(%q, %q) got=%v, want=%v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestTeamRegistry(t *testing.T) {
+
+	registry := NewTeamRegistry()
+
+	if err := registry.update([]byte(testTeamRegistry)); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name           string
+		value          string
+		wantKnown      bool
+		wantSuggestion string
+	}{
+		{name: "registered team name", value: "platform", wantKnown: true},
+		{name: "registered alias with different case", value: "Infra", wantKnown: true},
+		{name: "typo of a team name", value: "platfrom", wantKnown: false, wantSuggestion: "platform"},
+		{name: "unrelated value", value: "marketing-analytics", wantKnown: false, wantSuggestion: ""},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+
+			if _, got := registry.lookup(tc.value); got != tc.wantKnown {
+				t.Errorf("lookup(%q) got=%v, want=%v", tc.value, got, tc.wantKnown)
+			}
+
+			if tc.wantKnown {
+				return
+			}
+
+			if got := registry.suggest(tc.value); got != tc.wantSuggestion {
+				t.Errorf("suggest(%q) got=%q, want=%q", tc.value, got, tc.wantSuggestion)
+			}
+		})
+	}
+
+	// an invalid registry must not replace the loaded teams
+	if err := registry.update([]byte("teams:\n  - name: a\n    aliases: [platform]\n  - name: platform\n")); err == nil {
+		t.Error("update() with a duplicate team name did not return an error")
+	}
+
+	if _, ok := registry.lookup("payments"); !ok {
+		t.Error("invalid update replaced the previously loaded teams")
+	}
+}
+
+func TestTeamRegistryWatchConfigMap(t *testing.T) {
+
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "teams", Namespace: "webhook-demo"},
+		Data:       map[string]string{"teams.yaml": testTeamRegistry},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	registry := NewTeamRegistry()
+	logger := log.New(io.Discard, "", log.Ldate)
+
+	if err := registry.watchConfigMap(ctx, client, "webhook-demo", "teams.yaml", logger, logger); err == nil {
+		t.Fatal("watchConfigMap() with an invalid reference did not return an error")
+	}
+
+	if err := registry.watchConfigMap(ctx, client, "webhook-demo/teams", "teams.yaml", logger, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !registry.isLoaded() {
+		if time.Now().After(deadline) {
+			t.Fatal("team registry was not loaded from the ConfigMap")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, ok := registry.lookup("payments"); !ok {
+		t.Error("team payments from the ConfigMap is not registered")
+	}
+}
+
+func TestCheckPodLabelsUnknownTeam(t *testing.T) {
+
+	registry := NewTeamRegistry()
+	if err := registry.update([]byte(testTeamRegistry)); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		errorLog: log.New(io.Discard, "", log.Ldate),
+		infoLog:  log.New(io.Discard, "", log.Ldate),
+		cfg:      &envConfig{Label: "owner"},
+		teams:    registry,
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "busybox1", Namespace: "webhook-demo", Labels: map[string]string{"owner": "platfrom"}},
+	}
+
+	_, violations := app.checkPodLabels(pod, &corev1.Namespace{})
+
+	if got, want := violations.Rules(), []string{"unknown-team/owner"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("checkPodLabels() failed rules - got=%v, want=%v", got, want)
+	}
+
+	want := "Denied because the label owner on the Pod has value platfrom that is not a registered team, did you mean platform?"
+	if got := violations[0].Message; got != want {
+		t.Errorf("denial message mismatch\nwant=%q\ngot= %q", want, got)
+	}
+}
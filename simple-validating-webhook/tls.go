@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// tlsProfile is a named set of TLS parameters, TLS_MIN_VERSION, TLS_CIPHER_SUITES and TLS_CURVES override it
+type tlsProfile struct {
+	minVersion   uint16
+	cipherSuites []uint16 // only used by TLS 1.2, the TLS 1.3 suites are not configurable
+	curves       []tls.CurveID
+}
+
+// tlsProfiles - the Mozilla server side TLS recommendations, https://wiki.mozilla.org/Security/Server_Side_TLS
+var tlsProfiles = map[string]tlsProfile{
+	"modern": {
+		minVersion: tls.VersionTLS13,
+		curves:     []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+	},
+	"intermediate": {
+		minVersion: tls.VersionTLS12,
+		cipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		curves: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+	},
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// NewTLSConfig - returns the TLS configuration of the admission listener from TLS_PROFILE and its overrides,
+// or an error if a parameter is unknown, insecure or can not be used with the minimum version
+func NewTLSConfig(cfg *envConfig) (*tls.Config, error) {
+
+	profile, found := tlsProfiles[cfg.TLSProfile]
+	if !found {
+		return nil, fmt.Errorf("unknown TLS_PROFILE %q, valid values are modern and intermediate", cfg.TLSProfile)
+	}
+
+	if cfg.TLSMinVersion != "" {
+		if profile.minVersion, found = tlsVersions[cfg.TLSMinVersion]; !found {
+			return nil, fmt.Errorf("unknown TLS_MIN_VERSION %q, valid values are 1.2 and 1.3", cfg.TLSMinVersion)
+		}
+		if profile.minVersion == tls.VersionTLS13 {
+			profile.cipherSuites = nil
+		}
+	}
+
+	if len(cfg.TLSCipherSuites) > 0 {
+		if profile.minVersion == tls.VersionTLS13 {
+			return nil, fmt.Errorf("TLS_CIPHER_SUITES can not be used with TLS 1.3 only, its cipher suites are not configurable")
+		}
+		suites, err := parseCipherSuites(cfg.TLSCipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		profile.cipherSuites = suites
+	}
+
+	if len(cfg.TLSCurves) > 0 {
+		profile.curves = nil
+		for _, name := range cfg.TLSCurves {
+			curve, found := tlsCurves[strings.TrimSpace(name)]
+			if !found {
+				return nil, fmt.Errorf("unknown TLS curve %q, valid values are X25519, P256, P384 and P521", name)
+			}
+			profile.curves = append(profile.curves, curve)
+		}
+	}
+
+	return &tls.Config{
+		MinVersion:       profile.minVersion,
+		CipherSuites:     profile.cipherSuites,
+		CurvePreferences: profile.curves,
+	}, nil
+}
+
+// parseCipherSuites - returns the IDs of the cipher suites named as in crypto/tls, e.g.
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, the insecure and the TLS 1.3 suites are rejected
+func parseCipherSuites(names []string) ([]uint16, error) {
+
+	supported := map[string]*tls.CipherSuite{}
+	for _, suite := range tls.CipherSuites() {
+		supported[suite.Name] = suite
+	}
+	insecure := map[string]bool{}
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		suite, found := supported[name]
+		switch {
+		case insecure[name]:
+			return nil, fmt.Errorf("the TLS cipher suite %v is insecure", name)
+		case !found:
+			return nil, fmt.Errorf("unknown TLS cipher suite %q", name)
+		case len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13:
+			return nil, fmt.Errorf("the TLS cipher suite %v is a TLS 1.3 suite, they are not configurable", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+
+	return ids, nil
+}
+
+// describeTLSConfig - returns the effective TLS parameters for the startup log
+func describeTLSConfig(profile string, c *tls.Config) string {
+
+	var suites []string
+	for _, id := range c.CipherSuites {
+		suites = append(suites, tls.CipherSuiteName(id))
+	}
+	switch {
+	case len(suites) > 0:
+	case c.MinVersion == tls.VersionTLS13:
+		suites = append(suites, "TLS 1.3 suites")
+	default:
+		suites = append(suites, "Go defaults")
+	}
+
+	// the curves are logged with the names of TLS_CURVES
+	var curves []string
+	for _, curve := range c.CurvePreferences {
+		for name, id := range tlsCurves {
+			if id == curve {
+				curves = append(curves, name)
+			}
+		}
+	}
+
+	return fmt.Sprintf("profile=%v min-version=%v cipher-suites=%v curves=%v",
+		profile, tls.VersionName(c.MinVersion), strings.Join(suites, ","), strings.Join(curves, ","))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+
+	tt := []struct {
+		name             string
+		cfg              envConfig
+		wantMinVersion   uint16
+		wantCipherSuites []uint16
+		wantCurves       []tls.CurveID
+		wantErr          string
+	}{
+		{
+			name:             "intermediate profile",
+			cfg:              envConfig{TLSProfile: "intermediate"},
+			wantMinVersion:   tls.VersionTLS12,
+			wantCipherSuites: tlsProfiles["intermediate"].cipherSuites,
+			wantCurves:       []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		},
+		{
+			name:           "modern profile",
+			cfg:            envConfig{TLSProfile: "modern"},
+			wantMinVersion: tls.VersionTLS13,
+			wantCurves:     []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		},
+		{
+			name:           "intermediate profile with TLS 1.3 only",
+			cfg:            envConfig{TLSProfile: "intermediate", TLSMinVersion: "1.3"},
+			wantMinVersion: tls.VersionTLS13,
+			wantCurves:     []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		},
+		{
+			name: "restricted cipher suites and curves",
+			cfg: envConfig{
+				TLSProfile:      "intermediate",
+				TLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", " TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+				TLSCurves:       []string{"P384"},
+			},
+			wantMinVersion:   tls.VersionTLS12,
+			wantCipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			wantCurves:       []tls.CurveID{tls.CurveP384},
+		},
+		{
+			name:    "unknown profile",
+			cfg:     envConfig{TLSProfile: "old"},
+			wantErr: "unknown TLS_PROFILE",
+		},
+		{
+			name:    "unknown minimum version",
+			cfg:     envConfig{TLSProfile: "intermediate", TLSMinVersion: "1.1"},
+			wantErr: "unknown TLS_MIN_VERSION",
+		},
+		{
+			name:    "cipher suites with TLS 1.3 only",
+			cfg:     envConfig{TLSProfile: "modern", TLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+			wantErr: "can not be used with TLS 1.3 only",
+		},
+		{
+			name:    "insecure cipher suite",
+			cfg:     envConfig{TLSProfile: "intermediate", TLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+			wantErr: "is insecure",
+		},
+		{
+			name:    "TLS 1.3 cipher suite",
+			cfg:     envConfig{TLSProfile: "intermediate", TLSCipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+			wantErr: "is a TLS 1.3 suite",
+		},
+		{
+			name:    "unknown cipher suite",
+			cfg:     envConfig{TLSProfile: "intermediate", TLSCipherSuites: []string{"ECDHE-RSA-AES128-GCM-SHA256"}},
+			wantErr: "unknown TLS cipher suite",
+		},
+		{
+			name:    "unknown curve",
+			cfg:     envConfig{TLSProfile: "intermediate", TLSCurves: []string{"secp256k1"}},
+			wantErr: "unknown TLS curve",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			c, err := NewTLSConfig(&tc.cfg)
+
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("NewTLSConfig() error - got=%v, want containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewTLSConfig() unexpected error - %v", err)
+			}
+
+			if c.MinVersion != tc.wantMinVersion {
+				t.Errorf("MinVersion - got=%v, want=%v", tls.VersionName(c.MinVersion), tls.VersionName(tc.wantMinVersion))
+			}
+			if !reflect.DeepEqual(c.CipherSuites, tc.wantCipherSuites) {
+				t.Errorf("CipherSuites - got=%v, want=%v", c.CipherSuites, tc.wantCipherSuites)
+			}
+			if !reflect.DeepEqual(c.CurvePreferences, tc.wantCurves) {
+				t.Errorf("CurvePreferences - got=%v, want=%v", c.CurvePreferences, tc.wantCurves)
+			}
+		})
+	}
+}
+
+func TestModernProfileRejectsTLS12Clients(t *testing.T) {
+
+	c, err := NewTLSConfig(&envConfig{TLSProfile: "modern"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = c
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, maxVersion := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.MaxVersion = maxVersion
+
+		res, err := (&http.Client{Transport: transport}).Get(srv.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+
+		if maxVersion == tls.VersionTLS12 && err == nil {
+			t.Errorf("TLS 1.2 client connected to a server with the modern profile")
+		}
+		if maxVersion == tls.VersionTLS13 && err != nil {
+			t.Errorf("TLS 1.3 client failed to connect - %v", err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"k8s.io/client-go/rest"
+)
+
+// SetupTracing - installs the global tracer provider that exports the spans to TRACING_EXPORTER, the
+// returned function flushes the spans that have not been exported yet, nothing is installed when the
+// tracing is disabled
+func SetupTracing(ctx context.Context, cfg *envConfig) (func(context.Context) error, error) {
+
+	var (
+		exporter sdktrace.SpanExporter
+		closer   io.Closer
+		err      error
+	)
+
+	if cfg.TracingExporter == "" || cfg.TracingExporter == "none" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	if cfg.TracingSampleRatio < 0 || cfg.TracingSampleRatio > 1 {
+		return nil, fmt.Errorf("TRACING_SAMPLE_RATIO must be between 0 and 1, got %v", cfg.TracingSampleRatio)
+	}
+
+	switch cfg.TracingExporter {
+	case "otlp":
+		// without TRACING_OTLP_ENDPOINT the exporter reads the OTEL_EXPORTER_OTLP_* environment variables
+		var opts []otlptracehttp.Option
+		if cfg.TracingOTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.TracingOTLPEndpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case "stdout":
+		var w io.Writer = os.Stdout
+		if cfg.TracingFile != "" {
+			f, ferr := os.OpenFile(cfg.TracingFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if ferr != nil {
+				return nil, fmt.Errorf("error opening the trace file - %v", ferr)
+			}
+			w, closer = f, f
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	default:
+		return nil, fmt.Errorf("unknown TRACING_EXPORTER %q, valid values are none, otlp and stdout", cfg.TracingExporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating the %v trace exporter - %v", cfg.TracingExporter, err)
+	}
+
+	attrs := []attribute.KeyValue{semconv.ServiceName(cfg.TracingServiceName)}
+	if cfg.PodName != "" {
+		attrs = append(attrs, semconv.K8SPodName(cfg.PodName))
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, attrs...))
+	if err != nil {
+		return nil, fmt.Errorf("error creating the trace resource - %v", err)
+	}
+
+	// the sampling decision of the API server is kept when it propagates its trace context
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			_ = closer.Close()
+		}
+		return err
+	}, nil
+}
+
+// traceKubeClient - traces the calls of the clients created from the config to the API server
+func traceKubeClient(config *rest.Config) {
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return otelhttp.NewTransport(rt, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return "kube-apiserver " + r.Method
+		}))
+	})
+}
+
+// traceRequests - starts a span for every request, continuing the trace context of the caller when present
+func traceRequests(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "admission", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method + " " + r.URL.Path
+	}))
+}
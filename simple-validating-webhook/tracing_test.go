@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// exportedSpan - the fields of a span written by the stdout exporter used by the tests
+type exportedSpan struct {
+	Name        string
+	SpanContext struct{ TraceID string }
+	Parent      struct{ SpanID string }
+}
+
+func TestTracingOfAdmissionRequests(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "traces.json")
+	cfg := &envConfig{
+		Label:              "owner",
+		Annotation:         "example.com/validate",
+		TracingExporter:    "stdout",
+		TracingFile:        file,
+		TracingSampleRatio: 0, // only the requests with a sampled parent are traced
+		TracingServiceName: "simple-validating-webhook",
+	}
+
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	shutdown, err := SetupTracing(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "webhook-demo",
+		Annotations: map[string]string{"example.com/validate": "true"},
+	}})
+	app := &application{
+		errorLog: log.New(io.Discard, "", log.Ldate),
+		infoLog:  log.New(io.Discard, "", log.Ldate),
+		cfg:      cfg,
+		client:   client,
+	}
+
+	srv := httptest.NewServer(app.setupRoutes())
+	defer srv.Close()
+
+	// the trace context of the API server
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	for _, traceparent := range []string{"00-" + traceID + "-00f067aa0ba902b7-01", ""} {
+		f, err := os.Open("test-files/admission-request-with-labels.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/validate", f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if traceparent != "" {
+			req.Header.Set("traceparent", traceparent)
+		}
+		res, err := http.DefaultClient.Do(req)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	spans := map[string]exportedSpan{}
+	for dec := json.NewDecoder(out); ; {
+		var span exportedSpan
+		if err := dec.Decode(&span); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if span.SpanContext.TraceID != traceID {
+			t.Errorf("span %v of an unsampled request or of another trace - trace=%v", span.Name, span.SpanContext.TraceID)
+		}
+		spans[span.Name] = span
+	}
+
+	for _, name := range []string{"POST /validate", "decode", "namespace lookup", "validate owner-label", "encode"} {
+		if _, found := spans[name]; !found {
+			t.Errorf("span %q not exported, got %v", name, spans)
+		}
+	}
+
+	if parent := spans["POST /validate"].Parent.SpanID; parent != "00f067aa0ba902b7" {
+		t.Errorf("parent of the request span - got=%v, want the span of the caller", parent)
+	}
+}
+
+func TestSpansOfTheCELRulesAndWASMPlugins(t *testing.T) {
+
+	rules, err := parseCELRules([]byte(`
+rules:
+  - name: app-label
+    expression: "object.metadata.labels.app == 'busybox1'"
+  - name: owner-prefix
+    expression: "object.metadata.labels.owner.matches('^team-')"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "plugin.wasm"), buildTestPlugin(`{"allowed": true}`, 1, false), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	plugins, err := LoadWASMPlugins(context.Background(), dir, wasmLimits{
+		MemoryPages:    2,
+		Timeout:        time.Second,
+		MaxConcurrency: 1,
+		FailurePolicy:  failurePolicyFail,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plugins.Close(context.Background())
+
+	data, err := os.ReadFile("test-files/admission-request-with-labels.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(data, &review); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		errorLog: log.New(io.Discard, "", log.Ldate),
+		infoLog:  log.New(io.Discard, "", log.Ldate),
+		cfg:      &envConfig{},
+		celRules: rules,
+		wasm:     plugins,
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "validate")
+
+	app.evaluateCELRules(ctx, review.Request, nil)
+	app.evaluateWASMPlugins(ctx, review.Request)
+	parent.End()
+
+	// the name of the rule or the plugin of every child span of the validator
+	got := map[string]string{}
+	for _, span := range recorder.Ended() {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			continue
+		}
+		for _, attr := range span.Attributes() {
+			if attr.Key == "cel.rule" || attr.Key == "wasm.plugin" {
+				got[attr.Value.AsString()] = span.Name()
+			}
+		}
+	}
+
+	want := map[string]string{"app-label": "cel rule", "owner-prefix": "cel rule", "plugin": "wasm plugin"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("spans of the CEL rules and the WASM plugins - got=%v, want=%v", got, want)
+	}
+}
+
+func TestSetupTracingValidatesTheSettings(t *testing.T) {
+
+	for _, cfg := range []envConfig{
+		{TracingExporter: "jaeger"},
+		{TracingExporter: "stdout", TracingSampleRatio: 2},
+	} {
+		if _, err := SetupTracing(context.Background(), &cfg); err == nil {
+			t.Errorf("SetupTracing() accepted exporter=%v ratio=%v", cfg.TracingExporter, cfg.TracingSampleRatio)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"simple-validating-webhook/webhook"
+)
+
+var (
+	podKind       = schema.GroupVersionKind{Group: "", Version: webhook.Any, Kind: "Pod"}
+	namespaceKind = schema.GroupVersionKind{Group: "", Version: webhook.Any, Kind: "Namespace"}
+)
+
+// audit annotation keys set by the validators, see the webhook package for the keys set on every response
+const (
+	auditKeyExemption      = "exemption"       // why the request is exempt
+	auditKeyEnforcement    = "enforcement"     // namespace mode and whether the namespace is enforced
+	auditKeyRequiredLabels = "required-labels" // labels checked on a Pod
+	auditKeyCELRules       = "cel-rules"       // CEL rules evaluated
+	auditKeyRegoQuery      = "rego-query"      // Rego decision queried
+	auditKeyWASMPlugins    = "wasm-plugins"    // WASM plugins called
+)
+
+// enforcementAudit - returns the value of the enforcement audit annotation, e.g. opt-in:enforced
+func (app *application) enforcementAudit(enforced bool) string {
+
+	mode := app.cfg.NamespaceMode
+	if mode == "" {
+		mode = namespaceModeOptIn
+	}
+
+	if enforced {
+		return mode + ":enforced"
+	}
+	return mode + ":skipped"
+}
+
+// withAudit - returns the result with the audit annotation added
+func withAudit(result webhook.Result, key, value string) webhook.Result {
+
+	annotations := make(map[string]string, len(result.AuditAnnotations)+1)
+	for k, v := range result.AuditAnnotations {
+		annotations[k] = v
+	}
+	annotations[key] = value
+	result.AuditAnnotations = annotations
+
+	return result
+}
+
+// newWebhookServer - returns the admission server with the named validators, in the order their
+// violations are reported, requests that match the exemptions are allowed without validation
+func (app *application) newWebhookServer(exempt exemptions, names ...string) *webhook.Server {
+
+	server := webhook.NewServer(app.infoLog, app.errorLog, app.getNamespace)
+
+	if app.cfg.FailurePolicy != "" {
+		server.SetFailurePolicy(webhook.FailurePolicy(app.cfg.FailurePolicy))
+	}
+
+	if app.limiter != nil {
+		server.SetLimiter(app.limiter)
+	}
+
+	if app.decisions != nil {
+		server.OnDecision(app.recordDecision)
+	}
+
+	if app.reports != nil {
+		server.OnDecision(app.recordPolicyResults)
+	}
+
+	for _, name := range names {
+		switch name {
+		case validatorOwnerLabel:
+			server.Register(app.gated(&ownerLabelValidator{app}, exempt, false))
+		case validatorNamespace:
+			server.Register(app.gated(&namespaceValidator{app}, exempt, false))
+		case validatorCEL:
+			server.Register(app.gated(&celValidator{app}, exempt, true))
+		case validatorRego:
+			server.Register(app.gated(&regoValidator{app}, exempt, true))
+		case validatorWASM:
+			server.Register(app.gated(&wasmValidator{app}, exempt, true))
+		}
+	}
+
+	return server
+}
+
+// gate skips the requests that match the exemptions and, when enforced is set, the namespaced
+// objects in namespaces where the validation is not enforced
+type gate struct {
+	webhook.Validator
+	app      *application
+	exempt   exemptions
+	enforced bool
+}
+
+// gated - wraps a validator with the exemptions and the namespace enforcement check
+func (app *application) gated(v webhook.Validator, exempt exemptions, enforced bool) webhook.Validator {
+	return &gate{Validator: v, app: app, exempt: exempt, enforced: enforced}
+}
+
+// Validate - calls the wrapped validator if the request is not exempt and the namespace of the object is enforced
+func (g *gate) Validate(ctx context.Context, req *webhook.Request) (webhook.Result, error) {
+
+	if reason := g.exempt.exemptReason(req.Namespace, req.UserInfo); reason != "" {
+		return withAudit(webhook.Result{Message: reason}, auditKeyExemption, reason), nil
+	}
+
+	// for Namespace objects the namespace of the request is the name of the object itself,
+	// other cluster scoped objects have no namespace
+	if !g.enforced || req.Kind.Kind == namespaceKind.Kind || req.Namespace == "" {
+		return g.Validator.Validate(ctx, req)
+	}
+
+	ns, err := req.NamespaceObject(ctx)
+	if err != nil {
+		return webhook.Result{}, fmt.Errorf("unable to check annotations on the %v - %v", req.Kind.Kind, err)
+	}
+
+	if !g.app.namespaceEnforced(ns) {
+		return withAudit(webhook.Result{Message: g.app.skipReason()}, auditKeyEnforcement, g.app.enforcementAudit(false)), nil
+	}
+
+	result, err := g.Validator.Validate(ctx, req)
+
+	return withAudit(result, auditKeyEnforcement, g.app.enforcementAudit(true)), err
+}
+
+// ownerLabelValidator checks that a Pod has the owner label and the labels required in its namespace
+type ownerLabelValidator struct {
+	app *application
+}
+
+func (v *ownerLabelValidator) Name() string { return "owner-label" }
+
+func (v *ownerLabelValidator) Handles() []webhook.Match {
+	return []webhook.Match{{GVK: podKind}}
+}
+
+// Validate - in the default opt-in mode, if the annotation Key "example.com/validate" was not preset or was set
+// to false on the namespace and the namespace does not match the selector, the validation is skipped
+func (v *ownerLabelValidator) Validate(ctx context.Context, req *webhook.Request) (webhook.Result, error) {
+
+	var pod corev1.Pod
+	if err := req.DecodeObject(&pod); err != nil {
+		return webhook.Result{}, err
+	}
+
+	// fetch the namespace once, it is used for the annotation check and the denial messages
+	ns, err := req.NamespaceObject(ctx)
+	if err != nil {
+		return webhook.Result{}, fmt.Errorf("unable to check annotations on the Pod - %v", err)
+	}
+
+	if !v.app.namespaceEnforced(ns) {
+		webhook.Logf(ctx, v.app.infoLog, "skipping validation of the Pod %s in namespace %s", pod.Name, req.Namespace)
+		return withAudit(webhook.Result{Message: v.app.skipReason()}, auditKeyEnforcement, v.app.enforcementAudit(false)), nil
+	}
+
+	checked, violations := v.app.checkPodLabels(&pod, ns)
+
+	return webhook.Result{
+		Violations: violations,
+		Message:    "Allowed as label " + strings.Join(checked, ", ") + " is present in the Pod",
+		AuditAnnotations: map[string]string{
+			auditKeyEnforcement:    v.app.enforcementAudit(true),
+			auditKeyRequiredLabels: strings.Join(checked, ","),
+		},
+	}, nil
+}
+
+// namespaceValidator checks the required labels and annotations of a Namespace on CREATE and that
+// only admins can disable the validation of the namespace on UPDATE
+type namespaceValidator struct {
+	app *application
+}
+
+func (v *namespaceValidator) Name() string { return "namespace" }
+
+func (v *namespaceValidator) Handles() []webhook.Match {
+	return []webhook.Match{{GVK: namespaceKind, Operations: []admissionv1.Operation{admissionv1.Create, admissionv1.Update}}}
+}
+
+func (v *namespaceValidator) Validate(ctx context.Context, req *webhook.Request) (webhook.Result, error) {
+
+	var ns, oldNs corev1.Namespace
+	if err := req.DecodeObject(&ns); err != nil {
+		return webhook.Result{}, err
+	}
+
+	var violations webhook.Violations
+
+	switch req.Operation {
+	case admissionv1.Create:
+		violations = v.app.checkNamespaceMetadata(&ns)
+	case admissionv1.Update:
+		if err := req.DecodeOldObject(&oldNs); err != nil {
+			return webhook.Result{}, err
+		}
+		violations = v.app.checkNamespaceEnforcementChange(&oldNs, &ns, req.UserInfo)
+	}
+
+	return webhook.Result{Violations: violations, Message: "Allowed as the Namespace " + ns.Name + " is valid"}, nil
+}
+
+// celValidator evaluates the CEL rules, it handles every kind a rule applies to
+type celValidator struct {
+	app *application
+}
+
+func (v *celValidator) Name() string { return "cel" }
+
+func (v *celValidator) Handles() []webhook.Match {
+
+	seen := map[string]bool{}
+	var matches []webhook.Match
+
+	for _, rule := range v.app.celRules {
+		for _, kind := range rule.Kinds {
+			if !seen[kind] {
+				seen[kind] = true
+				matches = append(matches, webhook.Match{GVK: schema.GroupVersionKind{Group: webhook.Any, Version: webhook.Any, Kind: kind}})
+			}
+		}
+	}
+
+	return matches
+}
+
+func (v *celValidator) Validate(ctx context.Context, req *webhook.Request) (webhook.Result, error) {
+
+	// namespaceObject is not bound for Namespace objects as they are cluster scoped
+	var ns *corev1.Namespace
+	if req.Kind.Kind != namespaceKind.Kind && req.Namespace != "" {
+		var err error
+		if ns, err = req.NamespaceObject(ctx); err != nil {
+			return webhook.Result{}, err
+		}
+	}
+
+	var names []string
+	for _, rule := range v.app.celRules {
+		if rule.appliesTo(req.Kind.Kind) {
+			names = append(names, rule.Name)
+		}
+	}
+
+	return webhook.Result{
+		Violations:       v.app.evaluateCELRules(ctx, req.AdmissionRequest, ns),
+		AuditAnnotations: map[string]string{auditKeyCELRules: strings.Join(names, ",")},
+	}, nil
+}
+
+// regoValidator evaluates the Rego policies with the AdmissionReview as input
+type regoValidator struct {
+	app *application
+}
+
+func (v *regoValidator) Name() string { return "rego" }
+
+func (v *regoValidator) Handles() []webhook.Match {
+	if v.app.rego == nil {
+		return nil
+	}
+	return []webhook.Match{{GVK: podKind}, {GVK: namespaceKind}}
+}
+
+func (v *regoValidator) Validate(ctx context.Context, req *webhook.Request) (webhook.Result, error) {
+	violations, warnings := v.app.evaluateRego(ctx, *req.Review)
+	return webhook.Result{
+		Violations:       violations,
+		Warnings:         warnings,
+		AuditAnnotations: map[string]string{auditKeyRegoQuery: v.app.rego.query},
+	}, nil
+}
+
+// wasmValidator calls the WASM plugins with the AdmissionRequest as input
+type wasmValidator struct {
+	app *application
+}
+
+func (v *wasmValidator) Name() string { return "wasm" }
+
+func (v *wasmValidator) Handles() []webhook.Match {
+	if v.app.wasm == nil || len(v.app.wasm.plugins) == 0 {
+		return nil
+	}
+	return []webhook.Match{{GVK: podKind}, {GVK: namespaceKind}}
+}
+
+func (v *wasmValidator) Validate(ctx context.Context, req *webhook.Request) (webhook.Result, error) {
+	names := make([]string, 0, len(v.app.wasm.plugins))
+	for _, plugin := range v.app.wasm.plugins {
+		names = append(names, plugin.name)
+	}
+
+	violations, warnings := v.app.evaluateWASMPlugins(ctx, req.AdmissionRequest)
+	return webhook.Result{
+		Violations:       violations,
+		Warnings:         warnings,
+		AuditAnnotations: map[string]string{auditKeyWASMPlugins: strings.Join(names, ",")},
+	}, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"simple-validating-webhook/webhook"
+)
+
+func TestAuditAnnotations(t *testing.T) {
+
+	tt := []struct {
+		name            string
+		sourceJsonFile  string
+		annotationValue string
+		exempt          exemptions
+		want            map[string]string
+		wantViolations  []string
+	}{
+		{
+			name:            "denied Pod records the enforcement, the checked labels and the violations",
+			sourceJsonFile:  "test-files/admission-request-missing-labels.json",
+			annotationValue: "true",
+			want: map[string]string{
+				webhook.AuditKeyDecision:   "denied",
+				webhook.AuditKeyValidators: "owner-label",
+				auditKeyEnforcement:        "opt-in:enforced",
+				auditKeyRequiredLabels:     "owner",
+			},
+			wantViolations: []string{ruleMissingLabel},
+		},
+		{
+			name:            "Pod in a namespace that is not enforced records the skipped enforcement",
+			sourceJsonFile:  "test-files/admission-request-missing-labels.json",
+			annotationValue: "false",
+			want: map[string]string{
+				webhook.AuditKeyDecision: "allowed",
+				auditKeyEnforcement:      "opt-in:skipped",
+			},
+		},
+		{
+			name:            "exempt Pod records the exemption",
+			sourceJsonFile:  "test-files/admission-request-missing-labels.json",
+			annotationValue: "true",
+			exempt:          exemptions{namespaces: toSet([]string{"webhook-demo"})},
+			want: map[string]string{
+				webhook.AuditKeyDecision: "allowed",
+				auditKeyExemption:        "skipping validation as the namespace webhook-demo is exempt",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := fake.NewSimpleClientset()
+			CreateNamespace(t, "webhook-demo", map[string]string{"example.com/validate": tc.annotationValue}, client)
+
+			app := &application{
+				errorLog: log.New(io.Discard, "", log.Ldate),
+				infoLog:  log.New(io.Discard, "", log.Ldate),
+				cfg:      &envConfig{Annotation: "example.com/validate", Label: "owner"},
+				client:   client,
+			}
+
+			f, err := os.Open(tc.sourceJsonFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			rr := httptest.NewRecorder()
+			app.newWebhookServer(tc.exempt, allValidators...).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/validate", f))
+
+			var review admissionv1.AdmissionReview
+			if err := json.NewDecoder(rr.Body).Decode(&review); err != nil {
+				t.Fatal(err)
+			}
+
+			got := review.Response.AuditAnnotations
+			for k, v := range tc.want {
+				if got[k] != v {
+					t.Errorf("audit annotation %v - got=%q, want=%q", k, got[k], v)
+				}
+			}
+
+			var violations webhook.Violations
+			if raw, ok := got[webhook.AuditKeyViolations]; ok {
+				if err := json.Unmarshal([]byte(raw), &violations); err != nil {
+					t.Fatalf("invalid violations audit annotation %q - %v", raw, err)
+				}
+			}
+
+			var rules []string
+			for _, v := range violations {
+				rules = append(rules, v.Rule)
+			}
+			if strings.Join(rules, ",") != strings.Join(tc.wantViolations, ",") {
+				t.Errorf("violations audit annotation - got=%v, want=%v", rules, tc.wantViolations)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"go.opentelemetry.io/otel/attribute"
+	admissionv1 "k8s.io/api/admission/v1"
+
+	"simple-validating-webhook/webhook"
+)
+
+// failure policies of FAILURE_POLICY and the WASM plugins, same values as the failurePolicy of a ValidatingWebhookConfiguration
+const (
+	failurePolicyFail   = "Fail"
+	failurePolicyIgnore = "Ignore"
+)
+
+// wasmMaxVerdictSize - upper bound of the verdict JSON returned by a plugin
+const wasmMaxVerdictSize = 1 << 20
+
+// wasmVerdict is the JSON document returned by the validate function of a plugin
+type wasmVerdict struct {
+	Allowed  bool     `json:"allowed"`
+	Message  string   `json:"message,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// wasmLimits holds the limits applied to every call of a plugin
+type wasmLimits struct {
+	MemoryPages    uint32        // maximum memory of a module instance in 64KiB pages
+	Timeout        time.Duration // maximum wall time of a call
+	MaxConcurrency int           // maximum concurrent calls of a plugin
+	FailurePolicy  string        // Fail or Ignore when a plugin misbehaves
+}
+
+// wasmPlugin is a compiled WebAssembly module, a new instance of the module is created for
+// every call so that calls do not share memory
+type wasmPlugin struct {
+	name     string
+	compiled wazero.CompiledModule
+	sem      chan struct{} // bounds the concurrent calls of the plugin
+}
+
+// wasmPlugins holds the runtime and the plugins loaded from a directory
+type wasmPlugins struct {
+	runtime wazero.Runtime
+	plugins []*wasmPlugin
+	limits  wasmLimits
+}
+
+// ValidateFailurePolicy - returns an error if the policy is not Fail or Ignore
+func ValidateFailurePolicy(policy string) error {
+
+	switch policy {
+	case failurePolicyFail, failurePolicyIgnore:
+		return nil
+	default:
+		return fmt.Errorf("invalid failure policy %q, must be %q or %q", policy, failurePolicyFail, failurePolicyIgnore)
+	}
+}
+
+// LoadWASMPlugins - compiles every .wasm file in the directory with a pure-Go runtime. A plugin must export
+// its memory and the functions
+//
+//	alloc(size i32) i32                 returns a buffer of size bytes for the AdmissionRequest JSON
+//	validate(ptr i32, len i32) i64      returns the verdict JSON location as ptr<<32 | len
+//
+// WASI is available to the plugins, e.g. for modules built with TinyGo or Rust for wasm32-wasi
+func LoadWASMPlugins(ctx context.Context, dir string, limits wasmLimits) (*wasmPlugins, error) {
+
+	if err := ValidateFailurePolicy(limits.FailurePolicy); err != nil {
+		return nil, err
+	}
+
+	if limits.MaxConcurrency < 1 {
+		return nil, fmt.Errorf("invalid WASM plugin concurrency %d, must be at least 1", limits.MaxConcurrency)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing the WASM plugins in %v - %v", dir, err)
+	}
+	sort.Strings(files)
+
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(limits.MemoryPages).
+		WithCloseOnContextDone(true))
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("error instantiating WASI - %v", err)
+	}
+
+	p := &wasmPlugins{runtime: runtime, limits: limits}
+
+	for _, file := range files {
+
+		name := strings.TrimSuffix(filepath.Base(file), ".wasm")
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			_ = p.Close(ctx)
+			return nil, fmt.Errorf("error reading the WASM plugin %v - %v", file, err)
+		}
+
+		compiled, err := runtime.CompileModule(ctx, data)
+		if err != nil {
+			_ = p.Close(ctx)
+			return nil, fmt.Errorf("error compiling the WASM plugin %v - %v", name, err)
+		}
+
+		exports := compiled.ExportedFunctions()
+		for _, fn := range []string{"alloc", "validate"} {
+			if _, ok := exports[fn]; !ok {
+				_ = p.Close(ctx)
+				return nil, fmt.Errorf("WASM plugin %v does not export the function %v", name, fn)
+			}
+		}
+
+		if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+			_ = p.Close(ctx)
+			return nil, fmt.Errorf("WASM plugin %v does not export its memory", name)
+		}
+
+		p.plugins = append(p.plugins, &wasmPlugin{
+			name:     name,
+			compiled: compiled,
+			sem:      make(chan struct{}, limits.MaxConcurrency),
+		})
+	}
+
+	return p, nil
+}
+
+// Close - releases the runtime and the compiled plugins
+func (p *wasmPlugins) Close(ctx context.Context) error {
+	return p.runtime.Close(ctx)
+}
+
+// call - runs the validate function of a plugin on a fresh module instance within the limits
+func (p *wasmPlugins) call(ctx context.Context, plugin *wasmPlugin, input []byte) (wasmVerdict, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, p.limits.Timeout)
+	defer cancel()
+
+	select {
+	case plugin.sem <- struct{}{}:
+		defer func() { <-plugin.sem }()
+	case <-ctx.Done():
+		return wasmVerdict{}, fmt.Errorf("timed out waiting for a free slot - %v", ctx.Err())
+	}
+
+	// an anonymous module can be instantiated more than once at the same time, _initialize is
+	// called for reactor modules and skipped when it is not exported
+	mod, err := p.runtime.InstantiateModule(ctx, plugin.compiled,
+		wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return wasmVerdict{}, fmt.Errorf("error instantiating - %v", err)
+	}
+	defer mod.Close(context.Background())
+
+	res, err := mod.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return wasmVerdict{}, fmt.Errorf("error calling alloc - %v", err)
+	}
+
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, input) {
+		return wasmVerdict{}, fmt.Errorf("alloc returned a buffer outside of the memory")
+	}
+
+	res, err = mod.ExportedFunction("validate").Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return wasmVerdict{}, fmt.Errorf("error calling validate - %v", err)
+	}
+
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	if outLen > wasmMaxVerdictSize {
+		return wasmVerdict{}, fmt.Errorf("verdict of %d bytes is larger than %d bytes", outLen, wasmMaxVerdictSize)
+	}
+
+	out, ok := mod.Memory().Read(outPtr, outLen)
+	if !ok {
+		return wasmVerdict{}, fmt.Errorf("validate returned a verdict outside of the memory")
+	}
+
+	var verdict wasmVerdict
+	if err := json.Unmarshal(out, &verdict); err != nil {
+		return wasmVerdict{}, fmt.Errorf("invalid verdict JSON - %v", err)
+	}
+
+	return verdict, nil
+}
+
+// evaluateWASMPlugins - calls every plugin with the AdmissionRequest JSON, a plugin that fails, times out
+// or exceeds its memory is handled according to the failure policy of the plugins
+func (a *application) evaluateWASMPlugins(ctx context.Context, req *admissionv1.AdmissionRequest) (webhook.Violations, []string) {
+
+	if a.wasm == nil || len(a.wasm.plugins) == 0 {
+		return nil, nil
+	}
+
+	input, err := json.Marshal(req)
+	if err != nil {
+		return webhook.Violations{{Rule: "wasm", Message: "Denied because the request could not be encoded for the WASM plugins - " + err.Error()}}, nil
+	}
+
+	var (
+		violations webhook.Violations
+		warnings   []string
+	)
+
+	for _, plugin := range a.wasm.plugins {
+
+		pluginCtx, span := webhook.StartSpan(ctx, "wasm plugin", attribute.String("wasm.plugin", plugin.name))
+		verdict, err := a.wasm.call(pluginCtx, plugin, input)
+		if err == nil {
+			span.SetAttributes(attribute.Bool("wasm.allowed", verdict.Allowed))
+		}
+		webhook.EndSpan(span, err)
+
+		if err != nil {
+			webhook.Logf(ctx, a.errorLog, "WASM plugin %v failed on %v %v/%v - %v", plugin.name, req.Kind.Kind, req.Namespace, req.Name, err)
+			if a.wasm.limits.FailurePolicy == failurePolicyIgnore {
+				warnings = append(warnings, fmt.Sprintf("WASM plugin %v failed and was ignored", plugin.name))
+				continue
+			}
+			violations = append(violations, webhook.Violation{
+				Rule:    "wasm:" + plugin.name,
+				Message: fmt.Sprintf("Denied because the WASM plugin %v failed - %v", plugin.name, err),
+			})
+			continue
+		}
+
+		warnings = append(warnings, verdict.Warnings...)
+
+		if verdict.Allowed {
+			continue
+		}
+
+		msg := verdict.Message
+		if msg == "" {
+			msg = "Denied by the WASM plugin " + plugin.name
+		}
+		violations = append(violations, webhook.Violation{Rule: "wasm:" + plugin.name, Message: msg})
+	}
+
+	return violations, warnings
+}
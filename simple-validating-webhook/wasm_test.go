@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// wasm opcodes and section ids used to assemble the test plugins
+const (
+	wasmSectionType     = 1
+	wasmSectionFunction = 3
+	wasmSectionMemory   = 5
+	wasmSectionExport   = 7
+	wasmSectionCode     = 10
+	wasmSectionData     = 11
+
+	wasmVerdictOffset = 32768 // well above the input written at offset 1024
+)
+
+// uleb128 - unsigned LEB128 encoding used for sizes and indexes
+func uleb128(v uint64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			out = append(out, b|0x80)
+			continue
+		}
+		return append(out, b)
+	}
+}
+
+// sleb128 - signed LEB128 encoding used for constants
+func sleb128(v int64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// wasmVec - encodes a vector of already encoded items
+func wasmVec(items ...[]byte) []byte {
+	out := uleb128(uint64(len(items)))
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+// wasmName - encodes a name
+func wasmName(name string) []byte {
+	return append(uleb128(uint64(len(name))), name...)
+}
+
+// wasmSection - encodes a section with its size
+func wasmSection(id byte, content []byte) []byte {
+	return append(append([]byte{id}, uleb128(uint64(len(content)))...), content...)
+}
+
+// buildTestPlugin - assembles a plugin with a single memory of memoryPages pages that returns the verdict
+// from a data segment, when loop is true validate never returns
+func buildTestPlugin(verdict string, memoryPages uint32, loop bool) []byte {
+
+	// alloc(size i32) i32 always returns the buffer at offset 1024
+	allocBody := []byte{0x00, 0x41}
+	allocBody = append(allocBody, sleb128(1024)...)
+	allocBody = append(allocBody, 0x0b)
+
+	// validate(ptr i32, len i32) i64 returns the location of the verdict as ptr<<32 | len
+	validateBody := []byte{0x00}
+	if loop {
+		validateBody = append(validateBody, 0x03, 0x40, 0x0c, 0x00, 0x0b) // loop br 0 end
+	}
+	validateBody = append(validateBody, 0x42)
+	validateBody = append(validateBody, sleb128(int64(wasmVerdictOffset)<<32|int64(len(verdict)))...)
+	validateBody = append(validateBody, 0x0b)
+
+	offset := append([]byte{0x41}, sleb128(wasmVerdictOffset)...)
+	offset = append(offset, 0x0b)
+
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	module = append(module, wasmSection(wasmSectionType, wasmVec(
+		[]byte{0x60, 0x01, 0x7f, 0x01, 0x7f},       // (i32) -> i32
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e}, // (i32, i32) -> i64
+	))...)
+	module = append(module, wasmSection(wasmSectionFunction, wasmVec([]byte{0x00}, []byte{0x01}))...)
+	module = append(module, wasmSection(wasmSectionMemory, wasmVec(append([]byte{0x00}, uleb128(uint64(memoryPages))...)))...)
+	module = append(module, wasmSection(wasmSectionExport, wasmVec(
+		append(wasmName("memory"), 0x02, 0x00),
+		append(wasmName("alloc"), 0x00, 0x00),
+		append(wasmName("validate"), 0x00, 0x01),
+	))...)
+	module = append(module, wasmSection(wasmSectionCode, wasmVec(
+		append(uleb128(uint64(len(allocBody))), allocBody...),
+		append(uleb128(uint64(len(validateBody))), validateBody...),
+	))...)
+	module = append(module, wasmSection(wasmSectionData, wasmVec(
+		append(append([]byte{0x00}, offset...), wasmName(verdict)...),
+	))...)
+
+	return module
+}
+
+func TestLoadWASMPlugins(t *testing.T) {
+
+	limits := wasmLimits{MemoryPages: 2, Timeout: time.Second, MaxConcurrency: 1, FailurePolicy: failurePolicyFail}
+
+	tt := []struct {
+		name    string
+		plugin  []byte
+		limits  wasmLimits
+		wantErr string
+	}{
+		{
+			name:   "valid plugin",
+			plugin: buildTestPlugin(`{"allowed": true}`, 1, false),
+			limits: limits,
+		},
+		{
+			name:    "plugin memory above the limit",
+			plugin:  buildTestPlugin(`{"allowed": true}`, 4, false),
+			limits:  limits,
+			wantErr: "error compiling the WASM plugin",
+		},
+		{
+			name:    "not a WASM module",
+			plugin:  []byte("not wasm"),
+			limits:  limits,
+			wantErr: "error compiling the WASM plugin",
+		},
+		{
+			name:    "invalid failure policy",
+			plugin:  buildTestPlugin(`{"allowed": true}`, 1, false),
+			limits:  wasmLimits{MemoryPages: 2, Timeout: time.Second, MaxConcurrency: 1, FailurePolicy: "Retry"},
+			wantErr: "invalid failure policy",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "plugin.wasm"), tc.plugin, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			plugins, err := LoadWASMPlugins(ctx, dir, tc.limits)
+
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("LoadWASMPlugins() unexpected error = %v", err)
+				}
+				_ = plugins.Close(ctx)
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("LoadWASMPlugins() error = %v, want error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluateWASMPlugins(t *testing.T) {
+
+	data, err := os.ReadFile("test-files/admission-request-with-labels.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(data, &review); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name          string
+		plugin        []byte
+		failurePolicy string
+		wantRules     []string
+		wantWarnings  []string
+	}{
+		{
+			name:          "plugin allows with a warning",
+			plugin:        buildTestPlugin(`{"allowed": true, "warnings": ["image tag latest"]}`, 1, false),
+			failurePolicy: failurePolicyFail,
+			wantRules:     []string{},
+			wantWarnings:  []string{"image tag latest"},
+		},
+		{
+			name:          "plugin denies",
+			plugin:        buildTestPlugin(`{"allowed": false, "message": "denied by the plugin"}`, 1, false),
+			failurePolicy: failurePolicyFail,
+			wantRules:     []string{"wasm:plugin/"},
+		},
+		{
+			name:          "plugin returns an invalid verdict",
+			plugin:        buildTestPlugin(`allowed`, 1, false),
+			failurePolicy: failurePolicyFail,
+			wantRules:     []string{"wasm:plugin/"},
+		},
+		{
+			name:          "plugin times out with failure policy Fail",
+			plugin:        buildTestPlugin(`{"allowed": true}`, 1, true),
+			failurePolicy: failurePolicyFail,
+			wantRules:     []string{"wasm:plugin/"},
+		},
+		{
+			name:          "plugin times out with failure policy Ignore",
+			plugin:        buildTestPlugin(`{"allowed": true}`, 1, true),
+			failurePolicy: failurePolicyIgnore,
+			wantRules:     []string{},
+			wantWarnings:  []string{"WASM plugin plugin failed and was ignored"},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "plugin.wasm"), tc.plugin, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			plugins, err := LoadWASMPlugins(ctx, dir, wasmLimits{
+				MemoryPages:    2,
+				Timeout:        100 * time.Millisecond,
+				MaxConcurrency: 1,
+				FailurePolicy:  tc.failurePolicy,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer plugins.Close(ctx)
+
+			app := &application{
+				errorLog: log.New(io.Discard, "", log.Ldate),
+				infoLog:  log.New(io.Discard, "", log.Ldate),
+				cfg:      &envConfig{},
+				wasm:     plugins,
+			}
+
+			violations, warnings := app.evaluateWASMPlugins(context.Background(), review.Request)
+
+			if got := violations.Rules(); !reflect.DeepEqual(got, tc.wantRules) {
+				t.Errorf("evaluateWASMPlugins() failed rules - got=%v, want=%v (%v)", got, tc.wantRules, violations.Messages())
+			}
+
+			if !reflect.DeepEqual(warnings, tc.wantWarnings) {
+				t.Errorf("evaluateWASMPlugins() warnings - got=%v, want=%v", warnings, tc.wantWarnings)
+			}
+		})
+	}
+}
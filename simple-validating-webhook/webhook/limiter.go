@@ -0,0 +1,114 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// reasons a request is shed by the Limiter
+const (
+	ShedQueueFull    = "queue-full"    // every slot is busy and the queue is full
+	ShedQueueTimeout = "queue-timeout" // no slot was free within the queue timeout
+	ShedDeadline     = "deadline"      // no slot was free before the deadline of the request
+)
+
+// Limiter bounds the requests validated at the same time, the requests that find every slot busy wait in
+// a bounded queue and are shed when the queue is full or no slot is free in time, a shed request is
+// answered with the failure policy instead of timing out at the API server. A Limiter can be shared by
+// several servers so that they share the capacity of the process
+type Limiter struct {
+	slots        chan struct{}
+	maxQueued    int64
+	queueTimeout time.Duration
+	queued       atomic.Int64
+	onShed       []func(reason string)
+}
+
+// overloadedError is returned when a request is shed
+type overloadedError struct {
+	reason string
+}
+
+func (e *overloadedError) Error() string {
+	return fmt.Sprintf("the webhook is overloaded: %v", e.reason)
+}
+
+// NewLimiter - returns a limiter of maxInFlight requests validated at the same time and maxQueued waiting
+// requests, a waiting request is shed after queueTimeout, 0 waits until the deadline of the request
+func NewLimiter(maxInFlight, maxQueued int, queueTimeout time.Duration) *Limiter {
+
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+
+	return &Limiter{
+		slots:        make(chan struct{}, maxInFlight),
+		maxQueued:    int64(maxQueued),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// OnShed - appends functions called with the reason of every shed request, e.g. to count them
+func (l *Limiter) OnShed(f ...func(reason string)) {
+	l.onShed = append(l.onShed, f...)
+}
+
+// InFlight - returns the number of requests being validated
+func (l *Limiter) InFlight() int {
+	return len(l.slots)
+}
+
+// Queued - returns the number of requests waiting for a slot
+func (l *Limiter) Queued() int {
+	return int(l.queued.Load())
+}
+
+// acquire - waits for a free slot and returns the function that releases it, or an overloadedError when
+// the request is shed, a nil limiter does not limit the requests
+func (l *Limiter) acquire(ctx context.Context) (func(), error) {
+
+	if l == nil {
+		return func() {}, nil
+	}
+
+	release := func() { <-l.slots }
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if l.queued.Add(1) > l.maxQueued {
+		l.queued.Add(-1)
+		return nil, l.shed(ShedQueueFull)
+	}
+	defer l.queued.Add(-1)
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		return nil, l.shed(ShedQueueTimeout)
+	case <-ctx.Done():
+		return nil, l.shed(ShedDeadline)
+	}
+}
+
+func (l *Limiter) shed(reason string) error {
+
+	for _, f := range l.onShed {
+		f(reason)
+	}
+
+	return &overloadedError{reason: reason}
+}
@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// panicError is returned when a validator panics, the request is answered with the failure policy
+// instead of crashing the server
+type panicError struct {
+	validator string
+	value     interface{}
+}
+
+func (e *panicError) Error() string {
+	if e.validator == "" {
+		return fmt.Sprintf("the validation panicked: %v", e.value)
+	}
+	return fmt.Sprintf("validator %v panicked: %v", e.validator, e.value)
+}
+
+// validate - calls the validator and turns a panic into an error
+func (s *Server) validate(ctx context.Context, v Validator, req *Request) (result Result, err error) {
+
+	defer func() {
+		if p := recover(); p != nil {
+			Logf(ctx, s.errorLog, "Validator %v panicked - %v\n%s", v.Name(), p, debug.Stack())
+			result, err = Result{}, &panicError{validator: v.Name(), value: p}
+		}
+	}()
+
+	return v.Validate(ctx, req)
+}
+
+// responseTracker records whether the response has been started, so that a panic after the response
+// was written is not answered a second time
+type responseTracker struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (t *responseTracker) WriteHeader(code int) {
+	t.written = true
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *responseTracker) Write(b []byte) (int, error) {
+	t.written = true
+	return t.ResponseWriter.Write(b)
+}
+
+func (t *responseTracker) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (t *responseTracker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := t.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("the response writer does not support hijacking")
+}
+
+// Unwrap - returns the wrapped writer for http.ResponseController
+func (t *responseTracker) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// recoverRequest - answers a request whose handling panicked outside of the validators, with the failure
+// policy once the AdmissionReview has been decoded, the panics of the side effects are only logged
+func (s *Server) recoverRequest(ctx context.Context, w *responseTracker, input *admissionv1.AdmissionReview, p interface{}) {
+
+	if p == http.ErrAbortHandler {
+		panic(p)
+	}
+
+	Logf(ctx, s.errorLog, "Recovered from a panic while serving the request - %v\n%s", p, debug.Stack())
+
+	switch {
+	case w.written:
+	case input.Request == nil:
+		s.writeErrorMessage(ctx, w, fmt.Sprintf("internal error: %v", p), http.StatusInternalServerError)
+	default:
+		s.writeFailure(ctx, w, *input, nil, &panicError{value: p})
+	}
+}
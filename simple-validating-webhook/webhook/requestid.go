@@ -0,0 +1,33 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID - returns a context carrying the ID of the HTTP request, the server adds it to its log
+// lines so that they can be matched with the access log
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID - returns the ID of the HTTP request carried by the context, empty when there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Logf - writes a line prefixed with the request ID of the context, the file and line of the caller
+// are kept for the loggers with log.Lshortfile
+func Logf(ctx context.Context, l *log.Logger, format string, args ...interface{}) {
+
+	msg := fmt.Sprintf(format, args...)
+	if id := RequestID(ctx); id != "" {
+		msg = "request_id=" + id + " " + msg
+	}
+
+	_ = l.Output(2, msg)
+}
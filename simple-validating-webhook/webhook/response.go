@@ -0,0 +1,107 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// audit annotation keys set by the server on every response
+const (
+	AuditKeyDecision   = "decision"   // allowed or denied
+	AuditKeyValidators = "validators" // comma separated names of the validators that ran
+	AuditKeyViolations = "violations" // JSON list of the violations of a denied request
+	AuditKeyFailure    = "failure"    // error of a validation that failed or timed out
+)
+
+// writeResult - writes the merged result of the validators as the AdmissionReview response
+func (s *Server) writeResult(ctx context.Context, w http.ResponseWriter, input admissionv1.AdmissionReview, validators []Validator, result Result) {
+
+	_, span := StartSpan(ctx, "encode")
+
+	msg := result.Message
+	if !result.Allowed() {
+		msg = result.Violations.Messages()
+	}
+
+	response := &admissionv1.AdmissionResponse{
+		UID:              input.Request.UID,
+		Allowed:          result.Allowed(),
+		Result:           &metav1.Status{Message: msg},
+		Warnings:         result.Warnings,
+		AuditAnnotations: s.auditAnnotations(validators, result),
+	}
+
+	err := writeResponse(w, input, response)
+	EndSpan(span, err)
+	if err != nil {
+		s.writeErrorMessage(ctx, w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// auditAnnotations - returns the audit annotations of the validators with the decision, the validators
+// that ran and the violations, which land in the audit log of the API server next to the request
+func (s *Server) auditAnnotations(validators []Validator, result Result) map[string]string {
+
+	annotations := make(map[string]string, len(result.AuditAnnotations)+3)
+	for k, v := range result.AuditAnnotations {
+		annotations[k] = v
+	}
+
+	names := make([]string, 0, len(validators))
+	for _, v := range validators {
+		names = append(names, v.Name())
+	}
+	annotations[AuditKeyValidators] = strings.Join(names, ",")
+
+	if result.Allowed() {
+		annotations[AuditKeyDecision] = "allowed"
+		return annotations
+	}
+
+	annotations[AuditKeyDecision] = "denied"
+	if violations, err := json.Marshal(result.Violations); err == nil {
+		annotations[AuditKeyViolations] = string(violations)
+	} else {
+		s.errorLog.Printf("Unable to encode the violations for the audit annotations - %v", err)
+	}
+
+	return annotations
+}
+
+// writeResponse - writes the response in the apiVersion and kind of the request
+func writeResponse(w http.ResponseWriter, input admissionv1.AdmissionReview, response *admissionv1.AdmissionResponse) error {
+	// we craft our final response here, which is an AdmissionReview object
+	// we set the correct fiels and update the message
+	output := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: input.TypeMeta.APIVersion,
+			Kind:       input.TypeMeta.Kind,
+		},
+		Response: response,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	resp, err := marshalResponse(output)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal the json object: %v", err)
+	}
+	if _, err := w.Write(resp); err != nil {
+		return fmt.Errorf("Unable to send HTTP response: %v", err)
+	}
+	return nil
+}
+
+// writeErrorMessage - writes error message to stderr and the http stream
+func (s *Server) writeErrorMessage(ctx context.Context, w http.ResponseWriter, msg string, code int) {
+
+	w.Header().Set("Content-Type", "application/json")
+	Logf(ctx, s.errorLog, "%v", msg)
+	msg = fmt.Sprintf(`{"error": "%v"}`, msg)
+	http.Error(w, msg, code)
+
+}
@@ -0,0 +1,391 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// Server is an http.Handler that decodes the AdmissionReview sent by the API server, runs the
+// validators that handle the request in the order they were registered and writes the response
+type Server struct {
+	infoLog       *log.Logger
+	errorLog      *log.Logger
+	namespaces    NamespaceGetter
+	validators    []Validator
+	failurePolicy FailurePolicy
+	sideEffects   []SideEffect
+	limiter       *Limiter
+	tracker       Tracker
+}
+
+// FailurePolicy decides the response when the validators fail or do not finish before the deadline,
+// same values as the failurePolicy of a ValidatingWebhookConfiguration
+type FailurePolicy string
+
+const (
+	// Fail - the request is denied, or answered with 500 Internal Server Error when a validator fails
+	Fail FailurePolicy = "Fail"
+	// Ignore - the request is allowed with a warning
+	Ignore FailurePolicy = "Ignore"
+)
+
+// SideEffect is called with the result of a request after the response was written, e.g. to record an
+// Event or an audit record, side effects are not called for dry-run requests
+type SideEffect func(ctx context.Context, req *Request, result Result)
+
+// Tracker is told about the requests being validated, from the time their AdmissionReview was decoded until
+// they were answered, e.g. to detect validators that are stuck. done is called once the request was answered
+type Tracker interface {
+	Track() (done func())
+}
+
+// deadlineFraction - share of the timeout of the API server given to the validators, the rest is
+// left to write the response before the API server gives up on the webhook
+const deadlineFraction = 0.9
+
+// NewServer - returns a server without validators, namespaces is used by Request.NamespaceObject
+// and can be nil when no validator needs the namespace of the object
+func NewServer(infoLog, errorLog *log.Logger, namespaces NamespaceGetter) *Server {
+
+	if infoLog == nil {
+		infoLog = log.New(io.Discard, "", 0)
+	}
+
+	if errorLog == nil {
+		errorLog = log.New(io.Discard, "", 0)
+	}
+
+	return &Server{infoLog: infoLog, errorLog: errorLog, namespaces: namespaces, failurePolicy: Fail}
+}
+
+// SetFailurePolicy - sets the response when the validators fail or time out, the default is Fail
+func (s *Server) SetFailurePolicy(policy FailurePolicy) {
+	s.failurePolicy = policy
+}
+
+// SetLimiter - bounds the requests validated at the same time, by default they are not limited
+func (s *Server) SetLimiter(limiter *Limiter) {
+	s.limiter = limiter
+}
+
+// SetTracker - reports the requests being validated to the tracker, by default they are not tracked
+func (s *Server) SetTracker(tracker Tracker) {
+	s.tracker = tracker
+}
+
+// OnDecision - appends side effects called after every request that is not a dry-run
+func (s *Server) OnDecision(sideEffects ...SideEffect) {
+	s.sideEffects = append(s.sideEffects, sideEffects...)
+}
+
+// Register - appends the validators to the chain
+func (s *Server) Register(validators ...Validator) {
+	s.validators = append(s.validators, validators...)
+}
+
+// ServeHTTP - handles a POST request with an AdmissionReview body
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	rw := &responseTracker{ResponseWriter: w}
+	var input admissionv1.AdmissionReview
+
+	// a panic must not crash the server, the validators recover on their own, see validate
+	defer func() {
+		if p := recover(); p != nil {
+			s.recoverRequest(r.Context(), rw, &input, p)
+		}
+	}()
+
+	s.serve(rw, r, &input)
+}
+
+// serve - decodes the AdmissionReview into input, runs the validators and writes the response
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, input *admissionv1.AdmissionReview) {
+
+	// Webhooks are sent a POST request, with Content-Type: application/json, with
+	// an AdmissionReview API object in the admission.k8s.io API group serialized to JSON as the body.
+	// The API server sends the first version of the admissionReviewVersions of the webhook that it
+	// supports, v1 and v1beta1 are accepted and the response is written in the same version
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+
+	// the slot is taken before the body is read, a burst of requests is not held in memory while it waits.
+	// It is given back on the early returns, or by runWithDeadline once the validators are done
+	release, shed := s.limiter.acquire(ctx)
+	defer func() {
+		if release != nil {
+			release()
+		}
+	}()
+
+	_, span := StartSpan(r.Context(), "decode")
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		EndSpan(span, err)
+		code := http.StatusBadRequest
+		if errors.As(err, new(*http.MaxBytesError)) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		s.writeErrorMessage(r.Context(), w, "Unable to read the POST request: "+err.Error(), code)
+		return
+	}
+
+	decoded, err := decodeReview(body)
+	EndSpan(span, err)
+	if err != nil {
+		s.writeErrorMessage(r.Context(), w, "Unable to decode the POST request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// check for various nil or empty values
+	if decoded.Request == nil || decoded.Request.RequestKind == nil {
+		Logf(r.Context(), s.errorLog, "Request object is nil")
+		s.writeErrorMessage(r.Context(), w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	*input = decoded
+
+	// a client that is slow to send the body is not a stuck validation
+	if s.tracker != nil {
+		defer s.tracker.Track()()
+	}
+
+	req := NewRequest(input, s.namespaces)
+	trace.SpanFromContext(r.Context()).SetAttributes(requestAttributes(req)...)
+
+	validators := s.validatorsFor(req)
+
+	// this is to catch the misconfiguration of the webhook definition
+	if len(validators) == 0 {
+		msg := fmt.Sprintf("Can not work with K8s %q objects, only with %v", req.Kind.Kind, strings.Join(s.kinds(), " and "))
+		s.writeErrorMessage(r.Context(), w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if shed != nil {
+		s.writeFailure(ctx, w, *input, validators, shed)
+		return
+	}
+
+	handOff := release
+	release = nil
+	result, err := s.runWithDeadline(ctx, req, validators, handOff)
+	if err != nil {
+		if errors.As(err, new(*BadRequestError)) {
+			s.writeErrorMessage(ctx, w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.writeFailure(ctx, w, *input, validators, err)
+		return
+	}
+
+	trace.SpanFromContext(r.Context()).SetAttributes(
+		attribute.Bool("admission.allowed", result.Allowed()),
+		attribute.StringSlice("admission.violations", result.Violations.Rules()),
+	)
+
+	if result.Allowed() {
+		Logf(ctx, s.infoLog, "Allowed %v of %v %q in namespace %q", req.Operation, req.Kind.Kind, req.Name, req.Namespace)
+	} else {
+		Logf(ctx, s.infoLog, "Denied %v of %v %q in namespace %q - %v", req.Operation, req.Kind.Kind, req.Name, req.Namespace,
+			result.Violations.Rules())
+	}
+
+	s.writeResult(ctx, w, *input, validators, result)
+
+	if req.IsDryRun() || len(s.sideEffects) == 0 {
+		return
+	}
+
+	// the response is sent before the side effects run, they are not bound by the deadline of the request
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	for _, sideEffect := range s.sideEffects {
+		sideEffect(context.WithoutCancel(r.Context()), req, result)
+	}
+}
+
+// writeFailure - answers a request whose validation failed with the failure policy: with Ignore the request
+// is allowed with a warning, with Fail it is denied when the validation timed out, panicked or was shed
+// and answered with 500 Internal Server Error otherwise
+func (s *Server) writeFailure(ctx context.Context, w http.ResponseWriter, input admissionv1.AdmissionReview, validators []Validator, err error) {
+
+	req := input.Request
+
+	if s.failurePolicy == Ignore {
+		Logf(ctx, s.errorLog, "Ignored the failed validation of %v %q in namespace %q - %v", req.Kind.Kind, req.Name, req.Namespace, err)
+		s.writeResult(ctx, w, input, validators, Result{
+			Warnings:         []string{"validation failed and was ignored: " + err.Error()},
+			AuditAnnotations: map[string]string{AuditKeyFailure: err.Error()},
+		})
+		return
+	}
+
+	var violation Violation
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		violation = Violation{Rule: "timeout", Message: "Denied as the validation did not finish in time"}
+	case errors.As(err, new(*panicError)):
+		violation = Violation{Rule: "internal-error", Message: "Denied as the validation failed unexpectedly"}
+	case errors.As(err, new(*overloadedError)):
+		violation = Violation{Rule: "overloaded", Message: "Denied as the webhook is overloaded, retry later"}
+	default:
+		s.writeErrorMessage(ctx, w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	Logf(ctx, s.errorLog, "Denied %v of %v %q in namespace %q - %v", req.Operation, req.Kind.Kind, req.Name, req.Namespace, err)
+	s.writeResult(ctx, w, input, validators, Result{
+		Violations:       Violations{violation},
+		AuditAnnotations: map[string]string{AuditKeyFailure: err.Error()},
+	})
+}
+
+// requestContext - returns the context of the request bounded by the timeout query parameter that
+// the API server sends with the timeoutSeconds of the webhook, e.g. /validate?timeout=10s
+func (s *Server) requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+
+	value := r.URL.Query().Get("timeout")
+	if value == "" {
+		return context.WithCancel(r.Context())
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		Logf(r.Context(), s.errorLog, "Ignoring the invalid timeout %q of the request", value)
+		return context.WithCancel(r.Context())
+	}
+
+	return context.WithTimeout(r.Context(), time.Duration(float64(timeout)*deadlineFraction))
+}
+
+// runWithDeadline - runs the validators and returns when they finish or when the context is done,
+// whichever comes first, the validators are expected to stop when the context is done, release is
+// called once they return
+func (s *Server) runWithDeadline(ctx context.Context, req *Request, validators []Validator, release func()) (Result, error) {
+
+	type outcome struct {
+		result Result
+		err    error
+	}
+
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := s.run(ctx, req, validators)
+		release()
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-ctx.Done():
+		return Result{}, fmt.Errorf("validation did not finish before the deadline: %w", ctx.Err())
+	}
+}
+
+// Evaluate - runs the validators that handle the request without the HTTP round trip and the deadline,
+// e.g. to audit the objects that exist in the cluster, handled is false when no validator handles the
+// kind and operation of the request, side effects are not called
+func (s *Server) Evaluate(ctx context.Context, req *Request) (result Result, handled bool, err error) {
+
+	validators := s.validatorsFor(req)
+	if len(validators) == 0 {
+		return Result{}, false, nil
+	}
+
+	result, err = s.run(ctx, req, validators)
+
+	return result, true, err
+}
+
+// validatorsFor - returns the validators that handle the kind and operation of the request
+func (s *Server) validatorsFor(req *Request) []Validator {
+
+	gvk := req.GVK()
+
+	var validators []Validator
+	for _, v := range s.validators {
+		for _, m := range v.Handles() {
+			if m.matches(gvk, req.Operation) {
+				validators = append(validators, v)
+				break
+			}
+		}
+	}
+
+	return validators
+}
+
+// kinds - returns the kinds handled by the registered validators, used in error messages
+func (s *Server) kinds() []string {
+
+	seen := map[string]bool{}
+	var kinds []string
+
+	for _, v := range s.validators {
+		for _, m := range v.Handles() {
+			if !seen[m.GVK.Kind] {
+				seen[m.GVK.Kind] = true
+				kinds = append(kinds, m.GVK.Kind)
+			}
+		}
+	}
+
+	return kinds
+}
+
+// run - runs the validators in a chain and merges their results, the messages of the allowed results
+// are de-duplicated as several validators can skip a request for the same reason
+func (s *Server) run(ctx context.Context, req *Request, validators []Validator) (Result, error) {
+
+	var (
+		merged   Result
+		messages []string
+		seen     = map[string]bool{}
+	)
+
+	for _, v := range validators {
+
+		vctx, span := StartSpan(ctx, "validate "+v.Name(), attribute.String("validator", v.Name()))
+		result, err := s.validate(vctx, v, req)
+		span.SetAttributes(attribute.Int("violations", len(result.Violations)))
+		EndSpan(span, err)
+		if err != nil {
+			return Result{}, fmt.Errorf("validator %v failed: %w", v.Name(), err)
+		}
+
+		merged.Violations = append(merged.Violations, result.Violations...)
+		merged.Warnings = append(merged.Warnings, result.Warnings...)
+
+		for k, v := range result.AuditAnnotations {
+			if merged.AuditAnnotations == nil {
+				merged.AuditAnnotations = map[string]string{}
+			}
+			merged.AuditAnnotations[k] = v
+		}
+
+		if result.Message != "" && !seen[result.Message] {
+			seen[result.Message] = true
+			messages = append(messages, result.Message)
+		}
+	}
+
+	merged.Message = strings.Join(messages, "; ")
+
+	return merged, nil
+}
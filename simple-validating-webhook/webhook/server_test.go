@@ -0,0 +1,686 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakeValidator returns a fixed result for the kind it handles
+type fakeValidator struct {
+	name    string
+	kind    string
+	ops     []admissionv1.Operation
+	result  Result
+	err     error
+	decode  bool // decode the object before returning the result
+	nsCalls *int // counts the Request.NamespaceObject calls that reached the getter
+}
+
+func (f *fakeValidator) Name() string { return f.name }
+
+func (f *fakeValidator) Handles() []Match {
+	return []Match{{GVK: schema.GroupVersionKind{Group: Any, Version: Any, Kind: f.kind}, Operations: f.ops}}
+}
+
+func (f *fakeValidator) Validate(ctx context.Context, req *Request) (Result, error) {
+
+	if f.decode {
+		var pod corev1.Pod
+		if err := req.DecodeObject(&pod); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if f.nsCalls != nil {
+		if _, err := req.NamespaceObject(ctx); err != nil {
+			return Result{}, err
+		}
+	}
+
+	return f.result, f.err
+}
+
+func newReview(t *testing.T, kind string, op admissionv1.Operation, object []byte) []byte {
+
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:         "705ab4f5-6393-11e8-b7cc-42010a800002",
+			Kind:        metav1.GroupVersionKind{Version: "v1", Kind: kind},
+			RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: kind},
+			Name:        "test",
+			Namespace:   "default",
+			Operation:   op,
+			Object:      runtime.RawExtension{Raw: object},
+		},
+	}
+
+	data, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestServer(t *testing.T) {
+
+	pod := []byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "test", "namespace": "default"}}`)
+
+	tt := []struct {
+		name         string
+		kind         string
+		op           admissionv1.Operation
+		object       []byte
+		validators   []Validator
+		statusCode   int
+		allowed      bool
+		message      string
+		wantWarnings []string
+	}{
+		{
+			name:   "Violations of all the matching validators are merged in order",
+			kind:   "Pod",
+			op:     admissionv1.Create,
+			object: pod,
+			validators: []Validator{
+				&fakeValidator{name: "a", kind: "Pod", result: Result{Violations: Violations{{Rule: "a", Message: "first"}}}},
+				&fakeValidator{name: "b", kind: Any, result: Result{Violations: Violations{{Rule: "b", Message: "second"}}, Warnings: []string{"warn"}}},
+				&fakeValidator{name: "c", kind: "Namespace", result: Result{Violations: Violations{{Rule: "c", Message: "not called"}}}},
+			},
+			statusCode:   http.StatusOK,
+			allowed:      false,
+			message:      "first; second",
+			wantWarnings: []string{"warn"},
+		},
+		{
+			name:   "Allowed messages are de-duplicated",
+			kind:   "Pod",
+			op:     admissionv1.Create,
+			object: pod,
+			validators: []Validator{
+				&fakeValidator{name: "a", kind: "Pod", result: Result{Message: "skipped"}},
+				&fakeValidator{name: "b", kind: "Pod", result: Result{Message: "skipped"}},
+				&fakeValidator{name: "c", kind: "Pod"},
+			},
+			statusCode: http.StatusOK,
+			allowed:    true,
+			message:    "skipped",
+		},
+		{
+			name:   "Validator is not called for other operations",
+			kind:   "Pod",
+			op:     admissionv1.Update,
+			object: pod,
+			validators: []Validator{
+				&fakeValidator{name: "a", kind: "Pod", ops: []admissionv1.Operation{admissionv1.Create}, result: Result{Violations: Violations{{Rule: "a"}}}},
+				&fakeValidator{name: "b", kind: "Pod", ops: []admissionv1.Operation{Any}, result: Result{Message: "ok"}},
+			},
+			statusCode: http.StatusOK,
+			allowed:    true,
+			message:    "ok",
+		},
+		{
+			name:       "Kind without validators is a bad request",
+			kind:       "Deployment",
+			op:         admissionv1.Create,
+			object:     pod,
+			validators: []Validator{&fakeValidator{name: "a", kind: "Pod"}, &fakeValidator{name: "b", kind: "Namespace"}},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Object that can not be decoded is a bad request",
+			kind:       "Pod",
+			op:         admissionv1.Create,
+			object:     []byte(`{"metadata": "invalid"}`),
+			validators: []Validator{&fakeValidator{name: "a", kind: "Pod", decode: true}},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Failed validator is an internal server error",
+			kind:       "Pod",
+			op:         admissionv1.Create,
+			object:     pod,
+			validators: []Validator{&fakeValidator{name: "a", kind: "Pod", err: errors.New("unavailable")}},
+			statusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			server := NewServer(nil, nil, nil)
+			server.Register(tc.validators...)
+
+			req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(newReview(t, tc.kind, tc.op, tc.object)))
+			rr := httptest.NewRecorder()
+
+			server.ServeHTTP(rr, req)
+
+			if rr.Code != tc.statusCode {
+				t.Fatalf("HTTP status code mismatch want=%v, got=%v - %v", tc.statusCode, rr.Code, rr.Body.String())
+			}
+
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var result admissionv1.AdmissionReview
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Response.UID != "705ab4f5-6393-11e8-b7cc-42010a800002" {
+				t.Errorf("response UID does not match the request UID - got=%v", result.Response.UID)
+			}
+
+			if result.Response.Allowed != tc.allowed {
+				t.Errorf("allowed mismatch want=%v, got=%v", tc.allowed, result.Response.Allowed)
+			}
+
+			if result.Response.Result.Message != tc.message {
+				t.Errorf("message mismatch want=%q, got=%q", tc.message, result.Response.Result.Message)
+			}
+
+			if !reflect.DeepEqual(result.Response.Warnings, tc.wantWarnings) {
+				t.Errorf("warnings mismatch want=%v, got=%v", tc.wantWarnings, result.Response.Warnings)
+			}
+
+			wantDecision := map[bool]string{true: "allowed", false: "denied"}[tc.allowed]
+			if got := result.Response.AuditAnnotations[AuditKeyDecision]; got != wantDecision {
+				t.Errorf("decision audit annotation want=%v, got=%v", wantDecision, got)
+			}
+		})
+	}
+}
+
+func TestRequestNamespaceObjectIsFetchedOnce(t *testing.T) {
+
+	calls := 0
+	getter := func(ctx context.Context, name string) (*corev1.Namespace, error) {
+		calls++
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+	}
+
+	server := NewServer(nil, nil, getter)
+	server.Register(
+		&fakeValidator{name: "a", kind: "Pod", nsCalls: &calls},
+		&fakeValidator{name: "b", kind: "Pod", nsCalls: &calls},
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(newReview(t, "Pod", admissionv1.Create, []byte(`{}`))))
+	rr := httptest.NewRecorder()
+
+	server.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("HTTP status code mismatch want=%v, got=%v - %v", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	if calls != 1 {
+		t.Errorf("namespace getter calls - got=%v, want=1", calls)
+	}
+}
+
+func TestServerAdmissionReviewVersions(t *testing.T) {
+
+	tt := []struct {
+		name       string
+		apiVersion string
+		kind       string
+		statusCode int
+	}{
+		{name: "v1 review", apiVersion: "admission.k8s.io/v1", kind: "AdmissionReview", statusCode: http.StatusOK},
+		{name: "v1beta1 review", apiVersion: "admission.k8s.io/v1beta1", kind: "AdmissionReview", statusCode: http.StatusOK},
+		{name: "unknown version", apiVersion: "admission.k8s.io/v2", kind: "AdmissionReview", statusCode: http.StatusBadRequest},
+		{name: "missing version", apiVersion: "", kind: "AdmissionReview", statusCode: http.StatusBadRequest},
+		{name: "other kind", apiVersion: "admission.k8s.io/v1", kind: "Pod", statusCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			server := NewServer(nil, nil, nil)
+			server.Register(&fakeValidator{name: "a", kind: "Pod", result: Result{Warnings: []string{"warn"}}})
+
+			var review map[string]interface{}
+			if err := json.Unmarshal(newReview(t, "Pod", admissionv1.Create, []byte(`{}`)), &review); err != nil {
+				t.Fatal(err)
+			}
+			review["apiVersion"], review["kind"] = tc.apiVersion, tc.kind
+
+			body, err := json.Marshal(review)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+
+			if rr.Code != tc.statusCode {
+				t.Fatalf("HTTP status code mismatch want=%v, got=%v - %v", tc.statusCode, rr.Code, rr.Body.String())
+			}
+
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var result admissionv1.AdmissionReview
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+
+			if result.APIVersion != tc.apiVersion || result.Kind != "AdmissionReview" {
+				t.Errorf("response version mismatch want=%v, got=%v %v", tc.apiVersion, result.APIVersion, result.Kind)
+			}
+
+			if !result.Response.Allowed || !reflect.DeepEqual(result.Response.Warnings, []string{"warn"}) {
+				t.Errorf("response not converted - %+v", result.Response)
+			}
+		})
+	}
+}
+
+// blockingValidator returns when the context of the request is done
+type blockingValidator struct {
+	deadline chan bool // receives true when the context has a deadline
+}
+
+func (b *blockingValidator) Name() string { return "blocking" }
+
+func (b *blockingValidator) Handles() []Match {
+	return []Match{{GVK: schema.GroupVersionKind{Group: Any, Version: Any, Kind: "Pod"}}}
+}
+
+func (b *blockingValidator) Validate(ctx context.Context, req *Request) (Result, error) {
+	_, ok := ctx.Deadline()
+	b.deadline <- ok
+	<-ctx.Done()
+	return Result{}, ctx.Err()
+}
+
+func TestServerFailurePolicy(t *testing.T) {
+
+	tt := []struct {
+		name         string
+		policy       FailurePolicy
+		validator    func(deadline chan bool) Validator
+		statusCode   int
+		allowed      bool
+		wantDeadline bool
+	}{
+		{
+			name:         "Fail denies the request at the deadline",
+			policy:       Fail,
+			validator:    func(d chan bool) Validator { return &blockingValidator{deadline: d} },
+			statusCode:   http.StatusOK,
+			allowed:      false,
+			wantDeadline: true,
+		},
+		{
+			name:         "Ignore allows the request at the deadline",
+			policy:       Ignore,
+			validator:    func(d chan bool) Validator { return &blockingValidator{deadline: d} },
+			statusCode:   http.StatusOK,
+			allowed:      true,
+			wantDeadline: true,
+		},
+		{
+			name:   "Ignore allows the request when a validator fails",
+			policy: Ignore,
+			validator: func(chan bool) Validator {
+				return &fakeValidator{name: "a", kind: "Pod", err: errors.New("unavailable")}
+			},
+			statusCode: http.StatusOK,
+			allowed:    true,
+		},
+		{
+			name:       "Fail denies the request when a validator panics",
+			policy:     Fail,
+			validator:  func(chan bool) Validator { return &panickingValidator{} },
+			statusCode: http.StatusOK,
+			allowed:    false,
+		},
+		{
+			name:       "Ignore allows the request when a validator panics",
+			policy:     Ignore,
+			validator:  func(chan bool) Validator { return &panickingValidator{} },
+			statusCode: http.StatusOK,
+			allowed:    true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			deadline := make(chan bool, 1)
+
+			server := NewServer(nil, nil, nil)
+			server.SetFailurePolicy(tc.policy)
+			server.Register(tc.validator(deadline))
+
+			body := bytes.NewReader(newReview(t, "Pod", admissionv1.Create, []byte(`{}`)))
+			rr := httptest.NewRecorder()
+
+			start := time.Now()
+			server.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/validate?timeout=200ms", body))
+
+			if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+				t.Errorf("response took %v, longer than the timeout", elapsed)
+			}
+
+			if rr.Code != tc.statusCode {
+				t.Fatalf("HTTP status code mismatch want=%v, got=%v - %v", tc.statusCode, rr.Code, rr.Body.String())
+			}
+
+			var result admissionv1.AdmissionReview
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Response.Allowed != tc.allowed {
+				t.Errorf("allowed mismatch want=%v, got=%v - %v", tc.allowed, result.Response.Allowed, result.Response.Result.Message)
+			}
+
+			if tc.allowed && len(result.Response.Warnings) == 0 {
+				t.Errorf("ignored failure has no warning")
+			}
+
+			if tc.wantDeadline && !<-deadline {
+				t.Errorf("validator context has no deadline")
+			}
+		})
+	}
+}
+
+// panickingValidator panics on every request, e.g. a nil pointer in a policy
+type panickingValidator struct{}
+
+func (p *panickingValidator) Name() string { return "panicking" }
+
+func (p *panickingValidator) Handles() []Match {
+	return []Match{{GVK: schema.GroupVersionKind{Group: Any, Version: Any, Kind: "Pod"}}}
+}
+
+func (p *panickingValidator) Validate(ctx context.Context, req *Request) (Result, error) {
+	var pod *corev1.Pod
+	return Result{Message: pod.Name}, nil
+}
+
+func TestServerRecoversFromPanics(t *testing.T) {
+
+	var logs bytes.Buffer
+	server := NewServer(nil, log.New(&logs, "", 0), nil)
+	server.Register(&fakeValidator{name: "a", kind: "Pod"})
+	server.OnDecision(func(context.Context, *Request, Result) { panic("side effect failed") })
+
+	r := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(newReview(t, "Pod", admissionv1.Create, []byte(`{}`))))
+	r = r.WithContext(WithRequestID(r.Context(), "req-1"))
+	rr := httptest.NewRecorder()
+
+	server.ServeHTTP(rr, r)
+
+	// the response was written before the side effect panicked
+	var result admissionv1.AdmissionReview
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatalf("response is not an AdmissionReview - %v", err)
+	}
+	if !result.Response.Allowed {
+		t.Errorf("request denied after the response was written - %v", result.Response.Result.Message)
+	}
+
+	if !strings.Contains(logs.String(), "request_id=req-1 Recovered from a panic") {
+		t.Errorf("panic not logged with the request ID - %q", logs.String())
+	}
+}
+
+func TestServerRequestBodyLimit(t *testing.T) {
+
+	server := NewServer(nil, nil, nil)
+	server.Register(&fakeValidator{name: "a", kind: "Pod"})
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(newReview(t, "Pod", admissionv1.Create, []byte(`{}`))))
+	r.Body = http.MaxBytesReader(rr, r.Body, 64)
+
+	server.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("HTTP status code mismatch want=%v, got=%v", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+}
+
+// heldValidator blocks every request until release is closed
+type heldValidator struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *heldValidator) Name() string { return "held" }
+
+func (h *heldValidator) Handles() []Match {
+	return []Match{{GVK: schema.GroupVersionKind{Group: Any, Version: Any, Kind: "Pod"}}}
+}
+
+func (h *heldValidator) Validate(ctx context.Context, req *Request) (Result, error) {
+	select {
+	case h.started <- struct{}{}:
+	default:
+	}
+	<-h.release
+	return Result{}, nil
+}
+
+func TestServerLimiter(t *testing.T) {
+
+	var (
+		mu   sync.Mutex
+		shed []string
+	)
+
+	limiter := NewLimiter(1, 1, 100*time.Millisecond)
+	limiter.OnShed(func(reason string) {
+		mu.Lock()
+		shed = append(shed, reason)
+		mu.Unlock()
+	})
+
+	held := &heldValidator{started: make(chan struct{}, 1), release: make(chan struct{})}
+	server := NewServer(nil, nil, nil)
+	server.SetLimiter(limiter)
+	server.Register(held)
+
+	post := func() *admissionv1.AdmissionReview {
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/validate",
+			bytes.NewReader(newReview(t, "Pod", admissionv1.Create, []byte(`{}`)))))
+
+		var review admissionv1.AdmissionReview
+		if err := json.NewDecoder(rr.Body).Decode(&review); err != nil {
+			t.Errorf("response is not an AdmissionReview - %v", err)
+		}
+		return &review
+	}
+
+	// the first request takes the only slot, the second one waits in the queue
+	first, queued := make(chan *admissionv1.AdmissionReview, 1), make(chan *admissionv1.AdmissionReview, 1)
+	go func() { first <- post() }()
+	<-held.started
+	go func() { queued <- post() }()
+
+	for deadline := time.Now().Add(time.Second); limiter.Queued() != 1; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("second request not queued - queued=%v", limiter.Queued())
+		}
+	}
+
+	// the queue is full, the third request is shed right away
+	start := time.Now()
+	if review := post(); review.Response.Allowed || !strings.Contains(review.Response.AuditAnnotations[AuditKeyViolations], "overloaded") {
+		t.Errorf("request with a full queue - allowed=%v, annotations=%v", review.Response.Allowed, review.Response.AuditAnnotations)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("request with a full queue was answered after %v", elapsed)
+	}
+
+	// the queued request is shed after the queue timeout
+	if review := <-queued; review.Response.Allowed {
+		t.Errorf("queued request allowed while the slot was busy")
+	}
+
+	close(held.release)
+
+	if review := <-first; !review.Response.Allowed {
+		t.Errorf("first request denied - %v", review.Response.Result.Message)
+	}
+	if review := post(); !review.Response.Allowed {
+		t.Errorf("request after the slot was released denied - %v", review.Response.Result.Message)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{ShedQueueFull, ShedQueueTimeout}; !reflect.DeepEqual(shed, want) {
+		t.Errorf("shed reasons - got=%v, want=%v", shed, want)
+	}
+	if limiter.InFlight() != 0 || limiter.Queued() != 0 {
+		t.Errorf("limiter not drained - inflight=%v, queued=%v", limiter.InFlight(), limiter.Queued())
+	}
+}
+
+// slotReader records whether a limiter slot was taken when the body was first read
+type slotReader struct {
+	body     *bytes.Reader
+	limiter  *Limiter
+	inFlight int
+}
+
+func (s *slotReader) Read(p []byte) (int, error) {
+	if s.inFlight < 0 {
+		s.inFlight = s.limiter.InFlight()
+	}
+	return s.body.Read(p)
+}
+
+func TestServerLimiterSlotAroundTheBody(t *testing.T) {
+
+	tests := []struct {
+		name string
+		body []byte
+		want int
+	}{
+		{name: "valid review", body: newReview(t, "Pod", admissionv1.Create, []byte(`{}`)), want: http.StatusOK},
+		{name: "invalid body", body: []byte(`{`), want: http.StatusBadRequest},
+		{name: "unhandled kind", body: newReview(t, "Service", admissionv1.Create, []byte(`{}`)), want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			limiter := NewLimiter(1, 0, 0)
+			server := NewServer(nil, nil, nil)
+			server.SetLimiter(limiter)
+			server.Register(&fakeValidator{name: "a", kind: "Pod"})
+
+			body := &slotReader{body: bytes.NewReader(tt.body), limiter: limiter, inFlight: -1}
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/validate", body))
+
+			if rr.Code != tt.want {
+				t.Errorf("HTTP status code mismatch want=%v, got=%v", tt.want, rr.Code)
+			}
+			if body.inFlight != 1 {
+				t.Errorf("slots taken while the body was read - got=%v, want=1", body.inFlight)
+			}
+			if limiter.InFlight() != 0 {
+				t.Errorf("slot not given back - inflight=%v", limiter.InFlight())
+			}
+		})
+	}
+}
+
+func TestServerSideEffectsSkipDryRun(t *testing.T) {
+
+	for _, dryRun := range []bool{false, true} {
+
+		calls := 0
+
+		server := NewServer(nil, nil, nil)
+		server.Register(&fakeValidator{name: "a", kind: "Pod"})
+		server.OnDecision(func(ctx context.Context, req *Request, result Result) {
+			calls++
+			if ctx.Err() != nil {
+				t.Errorf("side effect called with a done context - %v", ctx.Err())
+			}
+		})
+
+		var review admissionv1.AdmissionReview
+		if err := json.Unmarshal(newReview(t, "Pod", admissionv1.Create, []byte(`{}`)), &review); err != nil {
+			t.Fatal(err)
+		}
+		review.Request.DryRun = &dryRun
+
+		body, err := json.Marshal(review)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+
+		if want := map[bool]int{false: 1, true: 0}[dryRun]; calls != want {
+			t.Errorf("side effect calls with dryRun=%v - got=%v, want=%v", dryRun, calls, want)
+		}
+	}
+}
+
+func TestServerEvaluate(t *testing.T) {
+
+	s := NewServer(nil, nil, nil)
+	s.Register(&fakeValidator{name: "pods", kind: "Pod", result: Result{Violations: Violations{{Rule: "r", Message: "denied"}}}})
+
+	newRequest := func(kind string) *Request {
+		var review admissionv1.AdmissionReview
+		if err := json.Unmarshal(newReview(t, kind, admissionv1.Create, []byte(`{}`)), &review); err != nil {
+			t.Fatal(err)
+		}
+		return NewRequest(&review, nil)
+	}
+
+	result, handled, err := s.Evaluate(context.Background(), newRequest("Pod"))
+	if err != nil || !handled || result.Allowed() {
+		t.Errorf("Evaluate() Pod - got=%+v handled=%v err=%v", result, handled, err)
+	}
+
+	if _, handled, err := s.Evaluate(context.Background(), newRequest("Service")); err != nil || handled {
+		t.Errorf("Evaluate() Service - handled=%v err=%v, want not handled", handled, err)
+	}
+}
+
+func TestLogf(t *testing.T) {
+
+	var buf bytes.Buffer
+	l := log.New(&buf, "INFO\t", log.Lshortfile)
+
+	Logf(WithRequestID(context.Background(), "abc"), l, "Allowed %v", "Pod")
+
+	// the file of the caller is logged, not the one of Logf
+	if got, want := buf.String(), "INFO\tserver_test.go:"; !strings.HasPrefix(got, want) || !strings.HasSuffix(got, ": request_id=abc Allowed Pod\n") {
+		t.Errorf("log line mismatch - got=%q", got)
+	}
+}
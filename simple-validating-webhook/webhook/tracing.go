@@ -0,0 +1,40 @@
+package webhook
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName - name of the tracer of the spans of the server
+const instrumentationName = "simple-validating-webhook/webhook"
+
+// StartSpan - starts a child span of the span in the context with the tracer provider of that span, e.g. the
+// span of a step of a validator. The spans are not recorded when the request is not traced, e.g. without an
+// instrumented HTTP handler
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan - records the error on the span, if any, and ends it
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// requestAttributes - the attributes of an admission request set on the span of the HTTP request
+func requestAttributes(req *Request) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("admission.uid", string(req.UID)),
+		attribute.String("admission.operation", string(req.Operation)),
+		attribute.String("admission.kind", req.Kind.Kind),
+		attribute.String("admission.namespace", req.Namespace),
+		attribute.String("admission.name", req.Name),
+		attribute.Bool("admission.dry_run", req.IsDryRun()),
+	}
+}
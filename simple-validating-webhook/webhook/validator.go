@@ -0,0 +1,196 @@
+// Package webhook implements a Kubernetes validating admission webhook that runs the registered
+// validators in a chain and answers the API server with a single AdmissionReview
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Any matches every group, version or kind in a Match
+const Any = "*"
+
+// Validator validates the admission requests of the kinds and operations it handles
+type Validator interface {
+	// Name identifies the validator in logs
+	Name() string
+	// Handles returns the kinds and operations the validator is called for
+	Handles() []Match
+	// Validate checks the request, an error means that the request could not be validated,
+	// e.g. because a dependency is unavailable, and fails the whole admission request
+	Validate(ctx context.Context, req *Request) (Result, error)
+}
+
+// Match selects admission requests by the kind of the object and the operation
+type Match struct {
+	GVK        schema.GroupVersionKind // group, version and kind can be set to Any
+	Operations []admissionv1.Operation // empty or Any matches every operation
+}
+
+// matches - returns true if the request is selected by the match
+func (m Match) matches(gvk schema.GroupVersionKind, op admissionv1.Operation) bool {
+
+	if (m.GVK.Group != Any && m.GVK.Group != gvk.Group) ||
+		(m.GVK.Version != Any && m.GVK.Version != gvk.Version) ||
+		(m.GVK.Kind != Any && m.GVK.Kind != gvk.Kind) {
+		return false
+	}
+
+	if len(m.Operations) == 0 {
+		return true
+	}
+
+	for _, o := range m.Operations {
+		if o == op || string(o) == Any {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Violation describes a single rule that the object failed
+type Violation struct {
+	Rule    string `json:"rule"`            // name of the failed rule
+	Field   string `json:"field,omitempty"` // label, annotation or field checked by the rule
+	Value   string `json:"value,omitempty"` // observed value of the field
+	Message string `json:"message"`         // message returned to the user
+}
+
+// Violations is a list of failed rules
+type Violations []Violation
+
+// Messages - joins the messages of all the violations into a single response message
+func (vs Violations) Messages() string {
+
+	msgs := make([]string, 0, len(vs))
+	for _, v := range vs {
+		msgs = append(msgs, v.Message)
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Rules - returns the failed rules in the form rule/field, used for logging
+func (vs Violations) Rules() []string {
+
+	rules := make([]string, 0, len(vs))
+	for _, v := range vs {
+		rules = append(rules, v.Rule+"/"+v.Field)
+	}
+
+	return rules
+}
+
+// Result is the outcome of a validator, the request is allowed when there are no violations
+type Result struct {
+	Violations Violations
+	Warnings   []string // returned to the user, e.g. shown by kubectl
+	Message    string   // message of an allowed request, e.g. why the validation was skipped
+
+	// AuditAnnotations are added to the audit event of the request by the API server, prefixed with
+	// the name of the webhook, keys must be valid label names without a prefix
+	AuditAnnotations map[string]string
+}
+
+// Allowed - returns true if the result has no violations
+func (r Result) Allowed() bool {
+	return len(r.Violations) == 0
+}
+
+// BadRequestError is returned by a validator when the request itself is invalid, e.g. the object can not
+// be decoded, the server answers it with 400 Bad Request instead of 500 Internal Server Error
+type BadRequestError struct {
+	Err error
+}
+
+func (e *BadRequestError) Error() string { return e.Err.Error() }
+
+func (e *BadRequestError) Unwrap() error { return e.Err }
+
+// NamespaceGetter returns the namespace object with the given name
+type NamespaceGetter func(ctx context.Context, name string) (*corev1.Namespace, error)
+
+// Request is the admission request passed to the validators
+type Request struct {
+	*admissionv1.AdmissionRequest
+
+	// Review is the AdmissionReview received from the API server
+	Review *admissionv1.AdmissionReview
+
+	namespaces NamespaceGetter
+	ns         *corev1.Namespace
+	nsErr      error
+	nsFetched  bool
+}
+
+// NewRequest - returns the request of the review, namespaces is used to look up the namespace of
+// the object and can be nil when no validator needs it
+func NewRequest(review *admissionv1.AdmissionReview, namespaces NamespaceGetter) *Request {
+	return &Request{AdmissionRequest: review.Request, Review: review, namespaces: namespaces}
+}
+
+// IsDryRun - returns true if the request will not be persisted, side effects have to be skipped
+func (r *Request) IsDryRun() bool {
+	return r.DryRun != nil && *r.DryRun
+}
+
+// GVK - returns the group, version and kind of the object in the request
+func (r *Request) GVK() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: r.Kind.Group, Version: r.Kind.Version, Kind: r.Kind.Kind}
+}
+
+// DecodeObject - decodes the object of the request into obj
+func (r *Request) DecodeObject(obj interface{}) error {
+
+	if len(r.Object.Raw) == 0 {
+		return &BadRequestError{fmt.Errorf("empty %v object in the request JSON", r.Kind.Kind)}
+	}
+
+	if err := json.Unmarshal(r.Object.Raw, obj); err != nil {
+		return &BadRequestError{fmt.Errorf("unable to marshal the raw payload into %v object: %v", r.Kind.Kind, err)}
+	}
+
+	return nil
+}
+
+// DecodeOldObject - decodes the old object of an UPDATE or DELETE request into obj
+func (r *Request) DecodeOldObject(obj interface{}) error {
+
+	if len(r.OldObject.Raw) == 0 {
+		return &BadRequestError{fmt.Errorf("empty old %v object in the request JSON", r.Kind.Kind)}
+	}
+
+	if err := json.Unmarshal(r.OldObject.Raw, obj); err != nil {
+		return &BadRequestError{fmt.Errorf("unable to marshal the raw payload into the old %v object: %v", r.Kind.Kind, err)}
+	}
+
+	return nil
+}
+
+// NamespaceObject - returns the namespace of a namespaced object, the namespace is fetched once
+// and shared by all the validators of the request
+func (r *Request) NamespaceObject(ctx context.Context) (*corev1.Namespace, error) {
+
+	if r.nsFetched {
+		return r.ns, r.nsErr
+	}
+
+	if r.namespaces == nil {
+		return nil, fmt.Errorf("no namespace getter configured")
+	}
+
+	ctx, span := StartSpan(ctx, "namespace lookup", attribute.String("namespace", r.AdmissionRequest.Namespace))
+	r.ns, r.nsErr = r.namespaces(ctx, r.AdmissionRequest.Namespace)
+	r.nsFetched = true
+	EndSpan(span, r.nsErr)
+
+	return r.ns, r.nsErr
+}
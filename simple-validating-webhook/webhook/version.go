@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// supported apiVersions of the AdmissionReview
+var (
+	apiVersionV1      = admissionv1.SchemeGroupVersion.String()
+	apiVersionV1beta1 = admissionv1beta1.SchemeGroupVersion.String()
+)
+
+// decodeReview - decodes an AdmissionReview of a supported apiVersion, a v1beta1 review is converted
+// to v1 so that the validators only see v1 requests
+func decodeReview(body []byte) (admissionv1.AdmissionReview, error) {
+
+	var meta metav1.TypeMeta
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return admissionv1.AdmissionReview{}, err
+	}
+
+	if meta.Kind != "AdmissionReview" {
+		return admissionv1.AdmissionReview{}, fmt.Errorf("unsupported kind %q, must be AdmissionReview", meta.Kind)
+	}
+
+	switch meta.APIVersion {
+
+	case apiVersionV1:
+		var review admissionv1.AdmissionReview
+		err := json.Unmarshal(body, &review)
+		return review, err
+
+	case apiVersionV1beta1:
+		var review admissionv1beta1.AdmissionReview
+		if err := json.Unmarshal(body, &review); err != nil {
+			return admissionv1.AdmissionReview{}, err
+		}
+		return reviewFromV1beta1(review), nil
+
+	default:
+		return admissionv1.AdmissionReview{}, fmt.Errorf("unsupported AdmissionReview apiVersion %q, must be %v or %v",
+			meta.APIVersion, apiVersionV1, apiVersionV1beta1)
+	}
+}
+
+// reviewFromV1beta1 - converts a v1beta1 AdmissionReview to v1, the apiVersion is kept so that
+// the response is written in the version of the request. Older API servers do not send the requestKind,
+// requestResource and requestSubResource of v1beta1, they default to the kind, resource and subResource
+func reviewFromV1beta1(in admissionv1beta1.AdmissionReview) admissionv1.AdmissionReview {
+
+	out := admissionv1.AdmissionReview{TypeMeta: in.TypeMeta}
+
+	if r := in.Request; r != nil {
+		out.Request = &admissionv1.AdmissionRequest{
+			UID:                r.UID,
+			Kind:               r.Kind,
+			Resource:           r.Resource,
+			SubResource:        r.SubResource,
+			RequestKind:        r.RequestKind,
+			RequestResource:    r.RequestResource,
+			RequestSubResource: r.RequestSubResource,
+			Name:               r.Name,
+			Namespace:          r.Namespace,
+			Operation:          admissionv1.Operation(r.Operation),
+			UserInfo:           r.UserInfo,
+			Object:             r.Object,
+			OldObject:          r.OldObject,
+			DryRun:             r.DryRun,
+			Options:            r.Options,
+		}
+		if out.Request.RequestKind == nil {
+			kind := r.Kind
+			out.Request.RequestKind = &kind
+		}
+		if out.Request.RequestResource == nil {
+			resource := r.Resource
+			out.Request.RequestResource = &resource
+		}
+		if out.Request.RequestSubResource == "" {
+			out.Request.RequestSubResource = r.SubResource
+		}
+	}
+
+	return out
+}
+
+// responseToV1beta1 - converts a v1 AdmissionReview response to v1beta1
+func responseToV1beta1(in admissionv1.AdmissionReview) admissionv1beta1.AdmissionReview {
+
+	out := admissionv1beta1.AdmissionReview{TypeMeta: in.TypeMeta}
+
+	if r := in.Response; r != nil {
+		out.Response = &admissionv1beta1.AdmissionResponse{
+			UID:              r.UID,
+			Allowed:          r.Allowed,
+			Result:           r.Result,
+			Patch:            r.Patch,
+			AuditAnnotations: r.AuditAnnotations,
+			Warnings:         r.Warnings,
+		}
+		if r.PatchType != nil {
+			pt := admissionv1beta1.PatchType(*r.PatchType)
+			out.Response.PatchType = &pt
+		}
+	}
+
+	return out
+}
+
+// marshalResponse - encodes the response in the apiVersion of the review
+func marshalResponse(output admissionv1.AdmissionReview) ([]byte, error) {
+
+	if output.APIVersion == apiVersionV1beta1 {
+		return json.Marshal(responseToV1beta1(output))
+	}
+
+	return json.Marshal(output)
+}